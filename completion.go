@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// fileFlags are the flags whose value is a file path.
+var fileFlags = []string{"r", "e", "i", "ejson", "ijson", "eledger", "ehtml", "batch", "backup", "db"}
+
+func printCompletion(flagset *flag.FlagSet, shell string) error {
+	stats := []string{"help"}
+	for _, cmd := range slices.Sorted(maps.Keys(statsCommands())) {
+		stats = append(stats, string(cmd))
+	}
+	yeet := append(slices.Clone(yeetTargets), "all")
+
+	switch shell {
+	case "bash":
+		var names []string
+		flagset.VisitAll(func(f *flag.Flag) {
+			if !slices.Contains(hiddenFlags, f.Name) {
+				names = append(names, "-"+f.Name)
+			}
+		})
+		fmt.Printf(`# bash completion for liet, source it or put it in your bash completions directory
+_liet() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        -w) COMPREPLY=($(compgen -W %q -- "$cur")); return ;;
+        -yeet) COMPREPLY=($(compgen -W %q -- "$cur")); return ;;
+        -completion) COMPREPLY=($(compgen -W "bash zsh" -- "$cur")); return ;;
+        -sort) COMPREPLY=($(compgen -W %q -- "$cur")); return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+    fi
+}
+complete -o default -F _liet liet
+`, strings.Join(stats, " "), strings.Join(yeet, " "), strings.Join(sortOrders, " "), strings.Join(names, " "))
+	case "zsh":
+		var specs []string
+		flagset.VisitAll(func(f *flag.Flag) {
+			if slices.Contains(hiddenFlags, f.Name) {
+				return
+			}
+			usage, _, _ := strings.Cut(f.Usage, "\n")
+			usage = strings.NewReplacer("[", `\[`, "]", `\]`, "'", `'\''`).Replace(usage)
+			spec := fmt.Sprintf("'-%s[%s]", f.Name, usage)
+			switch {
+			case f.Name == "w":
+				spec += fmt.Sprintf("::stats:(%s)", strings.Join(stats, " "))
+			case f.Name == "yeet":
+				spec += fmt.Sprintf("::targets:(%s)", strings.Join(yeet, " "))
+			case f.Name == "completion":
+				spec += ":shell:(bash zsh)"
+			case f.Name == "sort":
+				spec += fmt.Sprintf(":order:(%s)", strings.Join(sortOrders, " "))
+			case slices.Contains(fileFlags, f.Name):
+				spec += ":file:_files"
+			case isBoolFlag(f):
+			default:
+				spec += ":" + f.Name + ":"
+			}
+			specs = append(specs, spec+"'")
+		})
+		fmt.Printf(`#compdef liet
+# zsh completion for liet, put it in a directory of your fpath as _liet
+
+_arguments \
+    %s \
+    '*::arguments:'
+`, strings.Join(specs, " \\\n    "))
+	default:
+		return fmt.Errorf("%w: unknown shell %q for completion, expecting bash or zsh", errUser, shell)
+	}
+	return nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
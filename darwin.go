@@ -0,0 +1,61 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	defaultDatabaseFile = `Library/Application Support/liet/liet.db`
+	defaultLedgersDir   = `Library/Application Support/liet`
+	defaultConfigFile   = `Library/Application Support/liet/liet.conf`
+	defaultLogFile      = `Library/Logs/liet/liet.log`
+)
+
+// userPath returns the path of a default file under the home directory.
+func userPath(homeDir, file string) string {
+	return filepath.Join(homeDir, file)
+}
+
+// terminalColumns returns the number of columns of the terminal attached to stdout, or 0 if there is none.
+func terminalColumns() int {
+	var size struct{ rows, columns, xPixels, yPixels uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0
+	}
+	return int(size.columns)
+}
+
+// setEcho turns the echo of the terminal attached to stdin on or off, e.g. to type a passphrase.
+func setEcho(on bool) error {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCGETA, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return errno
+	}
+	if on {
+		termios.Lflag |= syscall.ECHO
+	} else {
+		termios.Lflag &^= syscall.ECHO
+	}
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TIOCSETA, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openFile opens a file with its default application, through open.
+func openFile(path string) error {
+	if err := exec.Command("open", path).Run(); err != nil { //nolint:gosec // the path of a receipt recorded by the user
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	return nil
+}
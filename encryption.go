@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	passphraseEnv = "LIET_PASSPHRASE"
+
+	// encryptedMagic starts the encrypted database files, followed by the salt, the nonce and the sealed database.
+	encryptedMagic = "LIETENC1"
+	saltSize       = 16
+	keySize        = 32 // AES-256
+	kdfIterations  = 600_000
+)
+
+// sqliteMagic starts every plaintext SQLite database file.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// readPassphrase returns the passphrase of the LIET_PASSPHRASE env var, or asks for it without echoing it, warning when
+// the terminal shows it anyway. Once ctx is done, e.g. on Ctrl-C, it stops waiting for it.
+func readPassphrase(ctx context.Context) (string, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return passphrase, nil
+	}
+	err := setEcho(false)
+	hidden := err == nil
+	if info, statErr := os.Stdin.Stat(); !hidden && statErr == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: the passphrase is shown as typed (%v), set %s to avoid it.\n", err, passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Database passphrase: ")
+	defer func() {
+		fmt.Fprintln(os.Stderr)
+		if !hidden {
+			return
+		}
+		if err := setEcho(true); err != nil {
+			slog.Error("Failed to restore the terminal echo", "error", err)
+		}
+	}()
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() { // byte by byte, so nothing after the line is taken from the standard input, e.g. of -batch -
+		var line []byte
+		b := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(b)
+			if n == 0 || b[0] == '\n' {
+				if len(line) == 0 && err != nil {
+					read <- result{err: fmt.Errorf("failed to read passphrase: %w", err)}
+					return
+				}
+				break
+			}
+			line = append(line, b[0])
+		}
+		read <- result{line: line}
+	}()
+	var r result
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("%w: interrupted while asking for the passphrase", errUser)
+	case r = <-read:
+	}
+	if r.err != nil {
+		return "", r.err
+	}
+	passphrase := strings.TrimSuffix(string(r.line), "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("%w: empty passphrase, set %s or type it when asked", errUser, passphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// decryptDatabase decrypts the database into a private directory next to it, returning the path of the plaintext copy
+// and a function that encrypts it back into place and removes the copy, which is kept if that fails. A missing or
+// plaintext database is encrypted when sealed, an unchanged or read only one is only removed. The caller seals it
+// once done, signals included, so a transaction in progress is never sealed halfway.
+func decryptDatabase(databasePath, passphrase string, readonly bool) (string, func() error, error) {
+	var salt, key, plaintext []byte
+	b, err := os.ReadFile(filepath.Clean(databasePath))
+	encrypted := err == nil && !bytes.HasPrefix(b, sqliteMagic)
+	switch {
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return "", nil, fmt.Errorf("failed to read database %q: %w", databasePath, err)
+	case encrypted:
+		salt, key, plaintext, err = decrypt(b, passphrase)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: failed to decrypt database %q", err, databasePath)
+		}
+	default: // a new database, or a plaintext one to encrypt
+		if err == nil {
+			slog.Info("Encrypting the plaintext database", "path", databasePath)
+			plaintext = b
+		}
+		salt, key, err = newKey(passphrase)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	// next to the database rather than in the shared temporary directory, only readable by the user
+	dir, err := os.MkdirTemp(filepath.Dir(databasePath), ".liet-decrypted-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create the directory of the decrypted database: %w", err)
+	}
+	plainPath := filepath.Join(dir, "liet.db")
+	if plaintext != nil || !readonly { // a new database is created with these permissions too
+		err = os.WriteFile(plainPath, plaintext, 0o600) //nolint:mnd // only the user can read it
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("failed to write decrypted database: %w", err)
+		}
+	}
+
+	seal := func() error {
+		sealed, err := os.ReadFile(filepath.Clean(plainPath))
+		if readonly || (encrypted && err == nil && bytes.Equal(sealed, plaintext)) { // nothing to write back
+			return os.RemoveAll(dir) //nolint:wrapcheck // the directory is ours, its path tells enough
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read decrypted database %q: %w", plainPath, err)
+		}
+		err = encryptFile(databasePath, sealed, key, salt)
+		if err != nil {
+			return fmt.Errorf("%w, the decrypted database is kept at %q", err, plainPath)
+		}
+		for _, suffix := range []string{"-wal", "-shm"} { // left by the plaintext database, if it was just encrypted
+			err = os.Remove(databasePath + suffix)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove plaintext database file: %w", err)
+			}
+		}
+		return os.RemoveAll(dir) //nolint:wrapcheck // the directory is ours, its path tells enough
+	}
+	return plainPath, seal, nil
+}
+
+// reseal encrypts the database back with the seal of decryptDatabase when done, reporting a failure itself as it runs
+// deferred.
+func reseal(seal func() error) {
+	if err := seal(); err != nil {
+		slog.Error("Failed to encrypt the database", "error", err)
+		fmt.Fprintf(os.Stderr, "Failed to encrypt the database: %v\n", err)
+	}
+}
+
+// encryptFile writes the plaintext encrypted to the path, aside first and then renamed, so a failure never leaves a half
+// written file.
+func encryptFile(path string, plaintext, key, salt []byte) error {
+	sealed, err := encrypt(plaintext, key, salt)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, sealed, 0o600) //nolint:mnd // only the user can read it
+	if err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("failed to replace %q with its encrypted version: %w", path, err)
+	}
+	return nil
+}
+
+// encryptWithPassphrase encrypts the file in place with a key of its own, e.g. a backup of the encrypted database.
+func encryptWithPassphrase(path, passphrase string) error {
+	plaintext, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	}
+	salt, key, err := newKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return encryptFile(path, plaintext, key, salt)
+}
+
+// newKey derives a key from the passphrase with a new random salt, returning both.
+func newKey(passphrase string) ([]byte, []byte, error) {
+	salt := make([]byte, saltSize)
+	_, _ = rand.Read(salt)
+	key, err := deriveKey(passphrase, salt)
+	return salt, key, err
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	return key, nil
+}
+
+func encrypt(plaintext, key, salt []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, _ = rand.Read(nonce)
+	out := append([]byte(encryptedMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, []byte(encryptedMagic)), nil
+}
+
+// decrypt returns the salt, the key and the plaintext of an encrypted database file.
+func decrypt(b []byte, passphrase string) ([]byte, []byte, []byte, error) {
+	rest, ok := bytes.CutPrefix(b, []byte(encryptedMagic))
+	if !ok || len(rest) < saltSize {
+		return nil, nil, nil, fmt.Errorf("%w: not an encrypted liet database", errUser)
+	}
+	salt, rest := rest[:saltSize], rest[saltSize:]
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, nil, nil, fmt.Errorf("%w: truncated encrypted database", errUser)
+	}
+	nonce, sealed := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, []byte(encryptedMagic))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: wrong passphrase or corrupted database", errUser)
+	}
+	return salt, key, plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_decryptDatabase(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "liet.db")
+	open := func(passphrase string) (*sql.DB, func() error) {
+		t.Helper()
+		plainPath, seal, err := decryptDatabase(databasePath, passphrase, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(filepath.Dir(plainPath)) != filepath.Dir(databasePath) {
+			t.Errorf("decrypted database at %q, want it next to %q", plainPath, databasePath)
+		}
+		if info, err := os.Stat(plainPath); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("decrypted database stat = %v, %v, want only readable by the user", info, err)
+		}
+		db, err := sql.Open("sqlite", plainPath+"?_pragma=journal_mode(WAL)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbInit(db); err != nil {
+			t.Fatal(err)
+		}
+		return db, seal
+	}
+
+	db, seal := open("correct horse")
+	if _, err := insertTransaction(db, 4250, "rent", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+	if err := seal(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(databasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(encryptedMagic)) || bytes.Contains(b, []byte("rent")) {
+		t.Error("expected the database file to be encrypted")
+	}
+
+	db, seal = open("correct horse")
+	var total cents
+	if err := db.QueryRow("SELECT SUM(cost) FROM transactions").Scan(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != 4250 {
+		t.Errorf("expected the transactions back after decrypting, got a total of %v", total)
+	}
+	_ = db.Close()
+	if err := seal(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(databasePath); !bytes.Equal(after, b) {
+		t.Error("expected the database file untouched when nothing was written")
+	}
+
+	plainPath, seal, err := decryptDatabase(databasePath, "correct horse", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(plainPath, []byte("changed"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := seal(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(databasePath); !bytes.Equal(after, b) {
+		t.Error("expected the database file untouched when read only")
+	}
+	if _, err := os.Stat(filepath.Dir(plainPath)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the decrypted copy removed, got %v", err)
+	}
+
+	if _, _, err := decryptDatabase(databasePath, "wrong", false); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error for a wrong passphrase, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	chartBarHeight = 24
+	chartBarGap    = 6
+	chartWidth     = 480 // of the longest bar
+)
+
+// htmlReport is the data of the HTML report template.
+type htmlReport struct {
+	Period    string
+	Generated string
+	Rows      []htmlReportRow
+	Total     string
+	Income    string // empty without income
+	Bars      []htmlReportBar
+	BarHeight int
+	Height    int // of the chart
+	// Currencies are the totals in other currencies, left out of the rows rather than added to the base currency.
+	Currencies []htmlReportCurrency
+}
+
+type htmlReportRow struct {
+	Category string
+	Cost     string
+	Share    string
+}
+
+// htmlReportCurrency is the total of the transactions in a currency other than the base one.
+type htmlReportCurrency struct {
+	Currency     string
+	Expenses     string
+	Income       string
+	Transactions int
+}
+
+// htmlReportBar is a bar of the expenses chart, already laid out.
+type htmlReportBar struct {
+	Category string
+	Cost     string
+	Y        int
+	TextY    int
+	Width    int
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>liet report, {{.Period}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2rem; }
+th, td { padding: 0.3rem 0.6rem; border-bottom: 1px solid #ddd; }
+th { text-align: left; }
+td.number { text-align: right; font-variant-numeric: tabular-nums; }
+tfoot td { font-weight: bold; border-top: 2px solid #222; }
+.bar { fill: #4a7ab5; }
+.label { font-size: 12px; dominant-baseline: middle; }
+footer { color: #888; font-size: 0.8rem; }
+</style>
+</head>
+<body>
+<h1>liet report</h1>
+<p>{{.Period}}</p>
+{{- if .Rows}}
+<table>
+<thead><tr><th>Category</th><th>Cost</th><th>Share</th></tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr><td>{{.Category}}</td><td class="number">{{.Cost}}</td><td class="number">{{.Share}}</td></tr>
+{{- end}}
+</tbody>
+<tfoot>
+<tr><td>Expenses</td><td class="number">{{.Total}}</td><td class="number">100.0%</td></tr>
+{{- if .Income}}
+<tr><td>Income</td><td class="number">{{.Income}}</td><td></td></tr>
+{{- end}}
+</tfoot>
+</table>
+{{- if .Bars}}
+<svg xmlns="http://www.w3.org/2000/svg" width="100%" viewBox="0 0 720 {{.Height}}" role="img" aria-label="Expenses per category">
+{{- range .Bars}}
+<text class="label" x="0" y="{{.TextY}}">{{.Category}}</text>
+<rect class="bar" x="120" y="{{.Y}}" width="{{.Width}}" height="{{$.BarHeight}}"><title>{{.Category}}: {{.Cost}}</title></rect>
+<text class="label" x="{{.Width}}" dx="126" y="{{.TextY}}">{{.Cost}}</text>
+{{- end}}
+</svg>
+{{- end}}
+{{- else if not .Currencies}}
+<p>No transactions found.</p>
+{{- end}}
+{{- if .Currencies}}
+<p>Not included above, in other currencies:</p>
+<table>
+<thead><tr><th>Currency</th><th>Expenses</th><th>Income</th><th>Transactions</th></tr></thead>
+<tbody>
+{{- range .Currencies}}
+<tr><td>{{.Currency}}</td><td class="number">{{.Expenses}}</td><td class="number">{{.Income}}</td>
+{{- /* a single line in the page */}}<td class="number">{{.Transactions}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+<footer>Generated by liet on {{.Generated}}.</footer>
+</body>
+</html>
+`))
+
+// dbExportHTML writes a self-contained HTML page with the cost of each category between the dates and a bar chart of
+// the expenses, e.g. to email a monthly report. The transactions in other currencies get a table of their own.
+func dbExportHTML(db database, filePath, startDate, endDate string, o statsOptions) error {
+	period := "all time"
+	switch from, to := displayDate(startDate, o.dateFormat), displayDate(endDate, o.dateFormat); {
+	case startDate != "" && endDate != "":
+		period = "from " + from + " to " + to
+	case startDate != "":
+		period = "since " + from
+	case endDate != "":
+		period = "until " + to
+	}
+	startDate, endDate = cmp.Or(startDate, "0000-00-00"), cmp.Or(endDate, "9999-12-31")
+	summaries, err := costAggregration(db, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+	report := htmlReport{Period: period, Generated: displayDate(time.Now().Format("2006-01-02"), o.dateFormat)}
+
+	shares := newCategoryShares(summaries, o)
+	for _, s := range shares.summaries {
+		report.Rows = append(report.Rows, htmlReportRow{
+			Category: s.categoryName(), Cost: o.formatCost(s.totalCost), Share: shares.share(s.totalCost),
+		})
+	}
+	if shares.other.count > 0 {
+		report.Rows = append(report.Rows, htmlReportRow{
+			Category: "Other", Cost: o.formatCost(shares.other.totalCost), Share: shares.share(shares.other.totalCost),
+		})
+	}
+	report.Total = o.formatCost(shares.expenses)
+	if shares.income != 0 {
+		report.Income = o.formatCost(shares.income)
+	}
+
+	var highest cents
+	for _, s := range shares.summaries {
+		highest = max(highest, s.totalCost)
+	}
+	bars := slices.DeleteFunc(slices.Clone(shares.summaries), func(s transactionSummary) bool { return s.totalCost <= 0 })
+	for i, s := range bars {
+		y := i * (chartBarHeight + chartBarGap)
+		report.Bars = append(report.Bars, htmlReportBar{
+			Category: s.categoryName(),
+			Cost:     o.formatCost(s.totalCost),
+			Y:        y,
+			TextY:    y + chartBarHeight/2, //nolint:mnd // the middle of the bar
+			Width:    max(1, int(s.totalCost*chartWidth/highest)),
+		})
+	}
+	report.BarHeight = chartBarHeight
+	report.Height = max(chartBarHeight, len(bars)*(chartBarHeight+chartBarGap))
+
+	totals, err := currencyTotals(db, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	o.currency = "" // the code follows the amount instead
+	for _, c := range totals {
+		report.Currencies = append(report.Currencies, htmlReportCurrency{
+			Currency:     c.currency,
+			Expenses:     o.formatCost(c.expenses) + " " + c.currency,
+			Income:       o.formatCost(c.income) + " " + c.currency,
+			Transactions: c.transactionsNumber,
+		})
+	}
+
+	f, err := os.Create(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to create export file %q: %w", filePath, err)
+	}
+	defer handleErrClose(f.Close)
+
+	w := bufio.NewWriter(f)
+	if err := htmlReportTemplate.Execute(w, report); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dbExportHTML(t *testing.T) {
+	db := newTestDB(t)
+	for _, tr := range []struct {
+		cost     cents
+		category string
+		date     string
+	}{
+		{1250, "food", "2023-10-01"},
+		{5000, "<script>", "2023-10-02"},
+		{-200000, "salary", "2023-10-03"},
+		{9900, "rent", "2023-09-01"},
+	} {
+		if _, err := insertTransaction(db, tr.cost, tr.category, "", tr.date); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usd := newTransaction(3000, "hotel", "", "2023-10-04")
+	usd.currency = "USD"
+	if _, err := usd.insert(db); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := dbExportHTML(db, path, "2023-10-01", "2023-10-31", statsOptions{currency: "€"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"from 2023-10-01 to 2023-10-31",
+		"<td>food</td><td class=\"number\">€12.50</td><td class=\"number\">20.0%</td>",
+		"&lt;script&gt;",
+		"<td>Expenses</td><td class=\"number\">€62.50</td>",
+		"<td>Income</td><td class=\"number\">€2000.00</td>",
+		`<rect class="bar" x="120" y="0" width="480" height="24"><title>&lt;script&gt;: €50.00</title></rect>`,
+		"Not included above, in other currencies:",
+		"<td>USD</td><td class=\"number\">30.00 USD</td><td class=\"number\">0.00 USD</td><td class=\"number\">1</td>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report is missing %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"<script>", "rent", "<title>salary", "<td>hotel</td>"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("report has %q:\n%s", unwanted, got)
+		}
+	}
+}
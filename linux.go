@@ -2,8 +2,75 @@
 
 package main
 
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
 const (
 	defaultDatabaseFile = `.local/share/liet.db`
+	defaultLedgersDir   = `.local/share/liet`
 	defaultConfigFile   = `.config/liet.conf`
 	defaultLogFile      = `.local/state/liet.log`
 )
+
+// xdgDirs are the XDG base directory env vars that relocate the default directories when set.
+var xdgDirs = map[string]string{
+	".local/share": "XDG_DATA_HOME",
+	".config":      "XDG_CONFIG_HOME",
+	".local/state": "XDG_STATE_HOME",
+}
+
+// userPath returns the path of a default file, under its XDG base directory when set, e.g. $XDG_DATA_HOME/liet.db,
+// or under the home directory otherwise. Relative XDG directories are invalid and ignored, as the spec asks.
+func userPath(homeDir, file string) string {
+	for dir, env := range xdgDirs {
+		rest, ok := strings.CutPrefix(file, dir+"/")
+		if base := os.Getenv(env); ok && filepath.IsAbs(base) {
+			return filepath.Join(base, rest)
+		}
+	}
+	return filepath.Join(homeDir, file)
+}
+
+// terminalColumns returns the number of columns of the terminal attached to stdout, or 0 if there is none.
+func terminalColumns() int {
+	var size struct{ rows, columns, xPixels, yPixels uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0
+	}
+	return int(size.columns)
+}
+
+// setEcho turns the echo of the terminal attached to stdin on or off, e.g. to type a passphrase.
+func setEcho(on bool) error {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return errno
+	}
+	if on {
+		termios.Lflag |= syscall.ECHO
+	} else {
+		termios.Lflag &^= syscall.ECHO
+	}
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openFile opens a file with the default application of the desktop, through xdg-open.
+func openFile(path string) error {
+	if err := exec.Command("xdg-open", path).Run(); err != nil { //nolint:gosec // the path of a receipt recorded by the user
+		return fmt.Errorf("failed to open %q with xdg-open: %w", path, err)
+	}
+	return nil
+}
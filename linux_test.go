@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func Test_userPath(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/data")
+	t.Setenv("XDG_CONFIG_HOME", "relative/config") // invalid, so ignored
+	t.Setenv("XDG_STATE_HOME", "")
+
+	tests := map[string]string{
+		defaultDatabaseFile: "/data/liet.db",
+		defaultLedgersDir:   "/data/liet",
+		defaultConfigFile:   "/home/me/.config/liet.conf",
+		defaultLogFile:      "/home/me/.local/state/liet.log",
+	}
+	for file, want := range tests {
+		if got := userPath("/home/me", file); got != want {
+			t.Errorf("userPath(%q) = %q, want %q", file, got, want)
+		}
+	}
+}
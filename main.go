@@ -2,18 +2,29 @@ package main
 
 import (
 	"bufio"
+	"cmp"
+	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	_ "modernc.org/sqlite"
 )
@@ -59,6 +70,27 @@ L version: %v
 	}
 }
 
+// appVersion returns the version set by the build system, or the module version of the build.
+func appVersion() string {
+	if b, ok := debug.ReadBuildInfo(); version == "" && ok {
+		return b.Main.Version // e.g. when installed with go install
+	}
+	return version
+}
+
+func printVersion() {
+	b, ok := debug.ReadBuildInfo()
+	fmt.Printf("liet %s\n", appVersion())
+	if !ok {
+		return
+	}
+	fmt.Printf("module %s %s\n", b.Main.Path, b.Main.Version)
+	fmt.Printf("go %s\n", b.GoVersion)
+	for _, d := range b.Deps {
+		fmt.Printf("dep %s %s\n", d.Path, d.Version)
+	}
+}
+
 func handleErrClose(f func() error) {
 	err := f()
 	if err != nil {
@@ -68,8 +100,10 @@ func handleErrClose(f func() error) {
 
 const (
 	configFileEnv = "LIET_CONFIG"
+	databaseEnv   = "LIET_DATABASE"
 	logLevelEnv   = "LIET_LOG_LEVEL"
 	logFileEnv    = "LIET_LOG_FILE"
+	logFormatEnv  = "LIET_LOG_FORMAT"
 	debugEnv      = "LIET_DEBUG"
 )
 
@@ -79,6 +113,7 @@ func configureLogger() (func() error, error) {
 		errs     []error
 		logLevel = os.Getenv(logLevelEnv)
 		logFile  = os.Getenv(logFileEnv)
+		format   = os.Getenv(logFormatEnv)
 		debug    = os.Getenv(debugEnv) != ""
 	)
 
@@ -87,95 +122,699 @@ func configureLogger() (func() error, error) {
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to get home directory: %w", err))
 		}
-		logFile = filepath.Join(homeDir, defaultLogFile)
+		logFile = userPath(homeDir, defaultLogFile)
+	}
+	closeLog := func() error { return nil }
+	f, err := openLogFile(logFile)
+	if err != nil { // a logging problem should not stop the program
+		fmt.Fprintf(os.Stderr, "Logging is disabled: %v\n", err)
+		w = io.Discard
+	} else {
+		w, closeLog = f, f.Close
+	}
+
+	l, err := parseLogLevel(logLevel)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if debug {
+		w = os.Stderr
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, &slog.HandlerOptions{Level: l})
+	case "json": // e.g. for a structured logs backend
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})
+	default:
+		errs = append(errs, fmt.Errorf("%w: invalid log format %q, expecting text or json", errUser, format))
+	}
+
+	if len(errs) > 0 {
+		return closeLog, errors.Join(errs...)
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return closeLog, nil
+}
+
+// parseLogLevel parses a level name, debug, info, warn or error in any case, or its slog number, e.g. -4 for debug.
+// The level defaults to info.
+func parseLogLevel(s string) (slog.Level, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return slog.LevelInfo, nil
 	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return slog.Level(n), nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("%w: invalid log level %q, expecting debug, info, warn, error or a number", errUser, s)
+	}
+	return l, nil
+}
+
+func openLogFile(logFile string) (*os.File, error) {
 	err := os.MkdirAll(filepath.Dir(logFile), 0o700) //nolint:mnd // reasonable dir permissions
 	if err != nil {
-		errs = append(errs, fmt.Errorf("failed to create log directory: %w", err))
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 	f, err := os.OpenFile(filepath.Clean(logFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600) //nolint:mnd // reasonable file permissions
 	if err != nil {
-		errs = append(errs, fmt.Errorf("failed to open log file %q: %w", logFile, err))
+		return nil, fmt.Errorf("failed to open log file %q: %w", logFile, err)
 	}
-	w = f
+	return f, nil
+}
+
+type arguments struct {
+	cost     cents
+	costSet  bool // whether a cost was given, since zero is a valid cost
+	category string
+}
+
+const defaultListLimit = 20
+
+// listFlag is a flag that can be used both as a boolean, e.g. -l, or with a limit, e.g. -l=100.
+type listFlag struct {
+	set   bool
+	limit int
+}
 
-	l, err := strconv.Atoi(logLevel)
-	if err != nil && logLevel != "" {
-		errs = append(errs, fmt.Errorf("invalid log level: %w", err))
+func (l *listFlag) String() string {
+	if l == nil || !l.set {
+		return ""
 	}
+	return strconv.Itoa(l.limit)
+}
 
-	if debug {
-		w = os.Stderr
+func (l *listFlag) Set(s string) error {
+	l.set = true
+	if s == "true" {
+		l.limit = defaultListLimit
+		return nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("failed to parse limit: %w", err)
+	}
+	if limit <= 0 {
+		return fmt.Errorf("%w: limit must be positive, got %d", errUser, limit)
+	}
+	l.limit = limit
+	return nil
+}
+
+func (l *listFlag) IsBoolFlag() bool { return true }
+
+// defaultStatsWindow is the stats view of a bare -w, unless the default_stats config tells otherwise.
+const defaultStatsWindow = "month"
+
+// statsFlag is a flag that can be used both as a boolean, e.g. -w for the default view, or with a view, e.g.
+// -w=monthly.
+type statsFlag struct {
+	set    bool
+	bare   bool   // given without a view, which can follow as an argument, e.g. -w monthly
+	window string // empty for the default view
+}
+
+func (s *statsFlag) String() string {
+	if s == nil || !s.set {
+		return ""
 	}
+	return s.window
+}
 
-	if len(errs) > 0 {
-		return f.Close, errors.Join(errs...)
+func (s *statsFlag) Set(value string) error {
+	s.set, s.bare, s.window = true, value == "true", value
+	if s.bare {
+		s.window = ""
 	}
+	return nil
+}
 
-	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
-		Level: slog.Level(l), // default is InfoLevel = 0
-	})))
+func (s *statsFlag) IsBoolFlag() bool { return true }
 
-	return f.Close, nil
+// amountFlag is an amount flag that tells whether it was given, e.g. -min 100.
+type amountFlag struct {
+	set    bool
+	amount cents
 }
 
-type arguments struct {
-	cost     float64
+func (a *amountFlag) String() string {
+	if a == nil || !a.set {
+		return ""
+	}
+	return a.amount.String()
+}
+
+func (a *amountFlag) Set(s string) error {
+	amount, err := parseAmount(s)
+	if err != nil {
+		return err
+	}
+	a.set, a.amount = true, amount
+	return nil
+}
+
+// splitFlag is a payment split across categories, e.g. -split "30 groceries;20 household".
+type splitFlag struct {
+	set   bool
+	parts []splitPart
+}
+
+// splitPart is the cost of a category in a split payment.
+type splitPart struct {
+	cost     cents
 	category string
 }
 
+func (s *splitFlag) String() string {
+	if s == nil || !s.set {
+		return ""
+	}
+	var parts []string
+	for _, p := range s.parts {
+		parts = append(parts, strings.TrimSpace(p.cost.String()+" "+p.category))
+	}
+	return strings.Join(parts, ";")
+}
+
+func (s *splitFlag) Set(value string) error {
+	var parts []splitPart
+	for part := range strings.SplitSeq(value, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue // a trailing ;
+		}
+		cost, err := parseAmount(fields[0])
+		if err != nil || cost <= 0 {
+			return fmt.Errorf("%w: invalid split %q, expecting <cost> [<category>], e.g. 30 groceries", errUser, strings.TrimSpace(part))
+		}
+		parts = append(parts, splitPart{cost: cost, category: strings.Join(fields[1:], " ")})
+	}
+	if len(parts) < 2 { //nolint:mnd // a split of a single part is just the transaction
+		return fmt.Errorf("%w: a split needs at least two parts separated by ;, got %q", errUser, value)
+	}
+	s.set, s.parts = true, parts
+	return nil
+}
+
+// of returns the parts of the split of a cost, the last one taking the cent left over by rounding, e.g. 33.33, 33.33
+// and 33.34 of 100, or an error when they add up to a different cost.
+func (s splitFlag) of(cost cents) ([]splitPart, error) {
+	var total cents
+	for _, p := range s.parts {
+		total += p.cost
+	}
+	if diff := cost - total; diff < -1 || diff > 1 {
+		return nil, fmt.Errorf("%w: the split adds up to %v, not to the cost of %v", errUser, total, cost)
+	}
+	parts := slices.Clone(s.parts)
+	parts[len(parts)-1].cost += cost - total
+	return parts, nil
+}
+
+// percentFlag is a percentage flag, given with or without the percent sign, e.g. -min-share 2%.
+type percentFlag float64
+
+func (p *percentFlag) String() string {
+	if p == nil || *p == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*p), 'f', -1, 64) + "%"
+}
+
+func (p *percentFlag) Set(s string) error {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid percentage %q, expecting e.g. 2%%", errUser, s)
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("%w: percentage %q must be between 0 and 100", errUser, s)
+	}
+	*p = percentFlag(percent)
+	return nil
+}
+
+// yeetFlag is a flag that can be used both as a boolean, e.g. -yeet, or with targets, e.g. -yeet=db,logs.
+type yeetFlag struct {
+	set     bool
+	targets []string
+}
+
+func (y *yeetFlag) String() string {
+	if y == nil || !y.set {
+		return ""
+	}
+	return strings.Join(y.targets, ",")
+}
+
+func (y *yeetFlag) Set(s string) error {
+	y.set = true
+	if s == "true" {
+		y.targets = []string{"all"}
+		return nil
+	}
+	y.targets = nil
+	for target := range strings.SplitSeq(s, ",") {
+		target = strings.ToLower(strings.TrimSpace(target))
+		if target != "all" && !slices.Contains(yeetTargets, target) {
+			return fmt.Errorf("%w: unknown target %q, expecting one of %s or all", errUser, target, strings.Join(yeetTargets, ", "))
+		}
+		y.targets = append(y.targets, target)
+	}
+	return nil
+}
+
+func (y *yeetFlag) IsBoolFlag() bool { return true }
+
+// renameFlag is a flag holding a category rename, e.g. -rename grocery=groceries.
+type renameFlag struct {
+	set      bool
+	from, to string
+}
+
+func (r *renameFlag) String() string {
+	if r == nil || !r.set {
+		return ""
+	}
+	return r.from + "=" + r.to
+}
+
+func (r *renameFlag) Set(s string) error {
+	from, to, ok := strings.Cut(s, "=")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || from == "" {
+		return fmt.Errorf("%w: expecting old=new, got %q", errUser, s)
+	}
+	r.set, r.from, r.to = true, from, to
+	return nil
+}
+
+// mergeFlag is a flag holding a categories merge, e.g. -merge food,restaurants=dining.
+type mergeFlag struct {
+	set     bool
+	sources []string
+	target  string
+}
+
+func (m *mergeFlag) String() string {
+	if m == nil || !m.set {
+		return ""
+	}
+	return strings.Join(m.sources, ",") + "=" + m.target
+}
+
+func (m *mergeFlag) Set(s string) error {
+	sources, target, ok := strings.Cut(s, "=")
+	target = strings.TrimSpace(target)
+	if !ok || target == "" {
+		return fmt.Errorf("%w: expecting source1,source2=target, got %q", errUser, s)
+	}
+	m.sources = nil
+	for source := range strings.SplitSeq(sources, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			return fmt.Errorf("%w: empty source category in %q", errUser, s)
+		}
+		m.sources = append(m.sources, source)
+	}
+	m.set, m.target = true, target
+	return nil
+}
+
+// columnsFlag is a flag mapping the columns of a bank CSV file to the transaction fields, e.g. -imap date=0,cost=2,comment=1.
+type columnsFlag struct {
+	set     bool
+	columns csvColumns
+}
+
+func (c *columnsFlag) String() string {
+	if c == nil || !c.set {
+		return ""
+	}
+	var fields []string
+	for _, f := range []struct {
+		name  string
+		index int
+	}{{"date", c.columns.date}, {"cost", c.columns.cost}, {"category", c.columns.category}, {"comment", c.columns.comment}} {
+		if f.index >= 0 {
+			fields = append(fields, f.name+"="+strconv.Itoa(f.index))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+func (c *columnsFlag) Set(s string) error {
+	columns := csvColumns{cost: -1, category: -1, comment: -1, date: -1, currency: -1}
+	for field := range strings.SplitSeq(s, ",") {
+		name, value, ok := strings.Cut(field, "=")
+		index, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || index < 0 {
+			return fmt.Errorf("%w: expecting field=column, e.g. cost=2, got %q", errUser, field)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "cost":
+			columns.cost = index
+		case "category":
+			columns.category = index
+		case "comment":
+			columns.comment = index
+		case "date":
+			columns.date = index
+		default:
+			return fmt.Errorf("%w: unknown field %q, expecting cost, category, comment or date", errUser, name)
+		}
+	}
+	if columns.cost < 0 || columns.date < 0 {
+		return fmt.Errorf("%w: the cost and date columns are required, got %q", errUser, s)
+	}
+	c.set, c.columns = true, columns
+	return nil
+}
+
 type flags struct {
-	comment   string
-	date      string
-	stats     string
-	exportCSV string
-	importCSV string
-	yeet      bool
+	comment      string
+	date         string
+	dateEnd      string
+	stats        statsFlag
+	exportCSV    string
+	importCSV    string
+	exportJSON   string
+	exportLedger string
+	exportHTML   string
+	importJSON   string
+	importMap    columnsFlag
+	importDate   string
+	batch        string
+	remove       int
+	undo         bool
+	edit         int
+	receipt      string
+	open         int
+	split        splitFlag
+	currency     string
+	list         listFlag
+	minCost      amountFlag
+	maxCost      amountFlag
+	income       bool
+	yeet         yeetFlag
+	rename       renameFlag
+	merge        mergeFlag
+	categories   bool
+	find         string
+	backup       string
+	compact      bool
+	archive      string
+	database     string
+	ledger       string
+	ledgers      bool
+	format       string
+	sort         string
+	minShare     percentFlag
+	counts       bool
+	rate         float64
+	check        bool
+	repl         bool
+	dryRun       bool
+	dedup        bool
+	readonly     bool
+	recur        string
+	recurSpec    string
+	version      bool
+	completion   string
+	man          bool
+	force        bool
+}
+
+// resolveDate turns relative dates, e.g. "yesterday" or "3 days ago", into YYYY-MM-DD, any other value is returned as is.
+func resolveDate(date string, now time.Time) string {
+	switch d := strings.ToLower(strings.Join(strings.Fields(date), " ")); d {
+	case "today":
+		return now.Format("2006-01-02")
+	case "yesterday":
+		return now.AddDate(0, 0, -1).Format("2006-01-02")
+	default:
+		n, ok := strings.CutSuffix(d, " days ago")
+		if !ok {
+			n, ok = strings.CutSuffix(d, " day ago")
+		}
+		days, err := strconv.Atoi(n)
+		if !ok || err != nil || days < 0 {
+			return date
+		}
+		return now.AddDate(0, 0, -days).Format("2006-01-02")
+	}
+}
+
+// checkFutureDate fails for a date after today, most likely a typo in the year, e.g. 2203-10-01.
+func checkFutureDate(date string, now time.Time) error {
+	day, _, _ := strings.Cut(date, " ")
+	if day > now.Format("2006-01-02") {
+		return fmt.Errorf("%w: the date %s is in the future", errUser, day)
+	}
+	return nil
+}
+
+// parseInterspersed parses the flags even when they come after the positional arguments, e.g. liet 10 food -c lunch,
+// returning the positional arguments.
+func parseInterspersed(flagset *flag.FlagSet, arguments []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flagset.Parse(arguments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse flags: %w", err)
+		}
+		arguments = flagset.Args()
+		if len(arguments) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, arguments[0])
+		arguments = arguments[1:]
+	}
 }
 
-func parse() (arguments, flags) {
+func parse(cmdline []string) (arguments, flags) {
 	f := flags{}
 	flagset := flag.NewFlagSet("liet", flag.ExitOnError)
-	flagset.StringVar(&f.comment, "c", "", "Additional context for the transaction")
-	flagset.StringVar(&f.date, "d", "", "Date of the transaction (YYYY-MM-DD), defaults to today")
-	flagset.StringVar(&f.stats, "w", "", `This is for when you ask: What am I doing with my life?
-Normal values can be: "last week", "last month", "all time" or "today". For an exaustive list run with -w help.`)
+	flagset.StringVar(&f.database, "db", "", `Path of the database to use for this run, e.g. a separate work ledger.
+Takes precedence over the LIET_DATABASE env var, which takes precedence over the config file database and the default`)
+	flagset.StringVar(&f.ledger, "ledger", "", `Use the database of a named ledger for this run, e.g. -ledger work.
+The ledger is created when missing`)
+	flagset.BoolVar(&f.ledgers, "ledgers", false, "List the named ledgers")
+	flagset.StringVar(&f.comment, "c", "", `Additional context for the transaction.
+The #hashtags in it tag the transaction, e.g. -c 'dinner #rome'`)
+	flagset.StringVar(&f.date, "d", "", `Date of the transaction (YYYY-MM-DD, today, yesterday or N days ago), defaults to today.
+A time of day can follow the date, e.g. -d "2023-10-01 14:30", it defaults to the current time for today and is left out for other days.
+When exporting, the first day to export`)
+	flagset.StringVar(&f.dateEnd, "dend", "", "When exporting, the last day to export (YYYY-MM-DD)")
+	flagset.Var(&f.stats, "w", `This is for when you ask: What am I doing with my life?
+Normal values can be: "last week", "last month", "all time" or "today". For an exaustive list run with -w help.
+Without a value it shows this month, or the default_stats of the config file`)
+	flagset.StringVar(&f.format, "format", "text", "Output format of the -w stats tables, text, md (Markdown) or csv")
+	flagset.StringVar(&f.sort, "sort", sortOrders[0], "Order of the -w category stats, "+strings.Join(sortOrders, ", "))
+	flagset.Var(&f.minShare, "min-share", `With -w, fold the categories under the share of the expenses into a single Other row,
+e.g. -min-share 2%`)
+	flagset.BoolVar(&f.counts, "counts", false, `With -w monthly or weekly, show the number of transactions next to each cost,
+e.g. 300.00 (12)`)
+	flagset.Float64Var(&f.rate, "rate", 0, `With -w, add a column with the costs converted at the rate, e.g. -rate 1.08 for dollars of euros.
+Overrides the report_rate of the config file`)
 	flagset.StringVar(&f.exportCSV, "e", "", "Export transactions to a file (CSV format)")
 	flagset.StringVar(&f.importCSV, "i", "", "Import transactions from a file (CSV format) replacing any current data")
-	flagset.BoolVar(&f.yeet, "yeet", false, "Remove all known user data of the application: database, logs, configs (use with caution!)")
+	flagset.StringVar(&f.exportJSON, "ejson", "", "Export transactions to a file (JSON format)")
+	flagset.StringVar(&f.exportLedger, "eledger", "", "Export transactions to a file (ledger journal format, for ledger or hledger)")
+	flagset.StringVar(&f.exportHTML, "ehtml", "", `Export a report of the cost of each category to a file (HTML format),
+a self-contained page with a chart of the expenses`)
+	flagset.StringVar(&f.importJSON, "ijson", "", "Import transactions from a file (JSON format) replacing any current data")
+	flagset.Var(&f.importMap, "imap", `With -i, the columns of a bank CSV file, counting from 0, e.g. -imap date=0,cost=2,comment=1,category=3.
+Its transactions are added to the current ones instead of replacing them`)
+	flagset.StringVar(&f.importDate, "idate-format", "YYYY-MM-DD", "With -i, the format of the dates in the file, e.g. DD/MM/YYYY")
+	flagset.BoolVar(&f.dryRun, "dry-run", false, `With -i or -ijson, validate the file and report what would be imported
+without changing anything`)
+	flagset.BoolVar(&f.dedup, "dedup", false, `With -i or -ijson, add to the current transactions skipping the ones already recorded,
+with the same cost, category, comment and date`)
+	flagset.BoolVar(&f.income, "income", false, "Record the transaction as income instead of an expense")
+	flagset.Var(&f.split, "split", `Split the cost across categories, recording a transaction per category with the same date and comment,
+e.g. 50 -split "30 groceries;20 household", the parts must add up to the cost`)
+	flagset.StringVar(&f.currency, "cur", "", `Currency of the transaction, an ISO 4217 code, e.g. -cur USD, defaults to the
+base_currency of the config file. The stats sum only the base currency, the others are shown apart`)
+	flagset.StringVar(&f.receipt, "r", "", "Attach a receipt file to the transaction, its path is stored and the file must exist")
+	flagset.BoolVar(&f.repl, "repl", false, `Add transactions interactively until exit, one per line as:
+<cost> [<category>] [-c <comment>] [-d <date>] [-income]`)
+	flagset.StringVar(&f.batch, "batch", "", `Add the transactions in a file, or the standard input with -batch -,
+one per line as: <cost> [<category>] [<comment>]
+Lines starting with # are ignored, all transactions get the -d date`)
+	flagset.Var(&f.list, "l", fmt.Sprintf(
+		"List the most recent transactions, defaults to %d but a limit can be given, e.g. -l 100", defaultListLimit,
+	))
+	flagset.Var(&f.minCost, "min", "With -l, list only the transactions costing at least the amount, income costs less than 0")
+	flagset.Var(&f.maxCost, "max", "With -l, list only the transactions costing at most the amount, income costs less than 0")
+	flagset.StringVar(&f.find, "find", "", "List the transactions with the given text in their category or comment")
+	flagset.IntVar(&f.open, "open", 0, "Open the receipt of the transaction with the given ID")
+	flagset.StringVar(&f.recur, "recur", "", `Manage recurring transactions: add <cost> [<category>] <cadence>, apply or list.
+The cadence is daily, weekly, monthly or yearly since the -d date, apply records the ones due up to today`)
+	flagset.IntVar(&f.remove, "rm", 0, "Remove the transaction with the given ID")
+	flagset.BoolVar(&f.undo, "undo", false, "Remove the last added transaction")
+	flagset.Var(&f.rename, "rename", `Rename a category in all transactions, e.g. -rename grocery=groceries.
+An empty new name removes the category`)
+	flagset.Var(&f.merge, "merge", "Merge categories into a single one in all transactions, e.g. -merge food,restaurants=dining")
+	flagset.BoolVar(&f.categories, "cats", false, "List the categories in use and their number of transactions")
+	flagset.IntVar(&f.edit, "edit", 0, "Edit the transaction with the given ID, only the supplied <cost>, <category>, -c and -d are updated")
+	flagset.StringVar(&f.backup, "backup", "", "Backup the database to a file, or to liet-backup-YYYY-MM-DD.db when given a directory")
+	flagset.BoolVar(&f.compact, "compact", false, "Compact the database, reclaiming the space of removed transactions")
+	flagset.StringVar(&f.archive, "archive", "", `Move the transactions before the date to an archive, e.g. -archive 2021-01-01,
+keeping the recent ones fast. The stats of windows reaching the archived dates still count them`)
+	flagset.Var(&f.yeet, "yeet", `Remove all known user data of the application: database, logs, configs (use with caution!)
+Specific targets can be given, e.g. -yeet db or -yeet config,logs, the valid targets are: db, config, logs or all`)
+	flagset.BoolVar(&f.readonly, "readonly", false, "Open the database read only, allowing the stats, listings and exports but no changes")
+	flagset.BoolVar(&f.check, "check", false, "Check that the config file and the database are usable, without recording anything")
+	flagset.StringVar(&f.completion, "completion", "", `Print the completion script of the given shell, bash or zsh,
+e.g. liet -completion zsh > ~/.zsh/completions/_liet`)
+	flagset.BoolVar(&f.version, "version", false, "Print the version and build information")
+	flagset.BoolVar(&f.man, "man", false, "Print the man page, e.g. liet -man > liet.1")
+	flagset.BoolVar(&f.force, "force", false, `Skip the confirmation prompts and checks,
+e.g. of -yeet, of an import replacing the current transactions, of a date in the future
+or of a category close to an existing one`)
 	flagset.Usage = func() {
 		fmt.Printf("Usage: %s [<cost> [<category>] [<flags>] | <flags>]\n", os.Args[0])
-		flagset.PrintDefaults()
+		printDefaults(flagset)
 		fmt.Printf("\nExamples:\n")
 		fmt.Printf("  %s 10.50 groceries\n", os.Args[0])
 		fmt.Printf("  %s 9.6 -c 'Bought some stuff' -d 2023-10-01\n", os.Args[0])
+		fmt.Printf("  %s 4.2 coffee -d yesterday\n", os.Args[0])
+		fmt.Printf("  %s 3.1 coffee -d '2023-10-01 08:15'\n", os.Args[0])
+		fmt.Printf("  %s -income 2500 salary\n", os.Args[0])
+		fmt.Printf("  %s 42.5 restaurants -r ~/receipts/dinner.pdf\n", os.Args[0])
+		fmt.Printf("  %s 50 -split '30 groceries;20 household'\n", os.Args[0])
+		fmt.Printf("  %s 25 taxi -cur USD\n", os.Args[0])
+		fmt.Printf("  %s -batch receipts.txt\n", os.Args[0])
+		fmt.Printf("  cat receipts.txt | %s -batch -\n", os.Args[0])
+		fmt.Printf("  %s -repl\n", os.Args[0])
 		fmt.Printf("  %s -w\n", os.Args[0])
+		fmt.Printf("  %s -w tag:rome\n", os.Args[0])
+		fmt.Printf("  %s -w monthly -format md\n", os.Args[0])
+		fmt.Printf("  %s -w monthly -counts\n", os.Args[0])
+		fmt.Printf("  %s -w \"last month\" -sort count\n", os.Args[0])
+		fmt.Printf("  %s -w \"all time\" -min-share 2%%\n", os.Args[0])
+		fmt.Printf("  %s -w month -rate 1.08\n", os.Args[0])
+		fmt.Printf("  %s -w monthly -format csv > monthly.csv\n", os.Args[0])
 		fmt.Printf("  %s -e transactions.csv\n", os.Args[0])
+		fmt.Printf("  %s -e september.csv -d 2023-09-01 -dend 2023-09-30\n", os.Args[0])
+		fmt.Printf("  %s -ejson transactions.json\n", os.Args[0])
+		fmt.Printf("  %s -eledger liet.journal\n", os.Args[0])
+		fmt.Printf("  %s -ehtml october.html -d 2023-10-01 -dend 2023-10-31\n", os.Args[0])
 		fmt.Printf("  %s -i import.csv\n", os.Args[0])
+		fmt.Printf("  %s -ijson import.json\n", os.Args[0])
+		fmt.Printf("  %s -i bank.csv -imap date=0,cost=2,comment=1 -idate-format DD/MM/YYYY\n", os.Args[0])
+		fmt.Printf("  %s -i bank.csv -dry-run\n", os.Args[0])
+		fmt.Printf("  %s -i october.csv -dedup\n", os.Args[0])
+		fmt.Printf("  %s -db work.db 42.5 lunch\n", os.Args[0])
+		fmt.Printf("  %s -ledger business -w month\n", os.Args[0])
+		fmt.Printf("  %s -ledgers\n", os.Args[0])
+		fmt.Printf("  %s -l 50\n", os.Args[0])
+		fmt.Printf("  %s -l -min 100\n", os.Args[0])
+		fmt.Printf("  %s -recur add 850 rent monthly -d 2023-10-01\n", os.Args[0])
+		fmt.Printf("  %s -recur apply\n", os.Args[0])
+		fmt.Printf("  %s -find kitchen\n", os.Args[0])
+		fmt.Printf("  %s -open 42\n", os.Args[0])
+		fmt.Printf("  %s -rm 42\n", os.Args[0])
+		fmt.Printf("  %s -undo\n", os.Args[0])
+		fmt.Printf("  %s -edit 42 12.30 restaurants -c 'Forgot the tip'\n", os.Args[0])
+		fmt.Printf("  %s -cats\n", os.Args[0])
+		fmt.Printf("  %s -rename grocery=groceries\n", os.Args[0])
+		fmt.Printf("  %s -merge food,restaurants=dining\n", os.Args[0])
+		fmt.Printf("  %s -backup ~/backups\n", os.Args[0])
+		fmt.Printf("  %s -compact\n", os.Args[0])
+		fmt.Printf("  %s -check\n", os.Args[0])
+		fmt.Printf("  %s -db snapshot.db -readonly -w monthly\n", os.Args[0])
 		fmt.Printf("  %s -yeet\n", os.Args[0])
+		fmt.Printf("  %s -yeet -force\n", os.Args[0])
+		fmt.Printf("  %s -yeet db\n", os.Args[0])
 		os.Exit(1)
 	}
-	err := flagset.Parse(os.Args[1:])
+	args, err := parseInterspersed(flagset, cmdline)
 	if err != nil {
 		panic(fmt.Errorf("oops, something went wrong... failed to parse flags: %w", err))
 	}
+	if f.version {
+		printVersion()
+		os.Exit(0)
+	}
+	if f.completion != "" {
+		err = printCompletion(flagset, f.completion)
+		feedbackOnErr(err)
+		os.Exit(0)
+	}
+	if f.man {
+		printManPage(os.Stdout, flagset)
+		os.Exit(0)
+	}
 
-	if f.date == "" {
-		f.date = time.Now().Format("2006-01-02")
+	f.date = resolveDate(f.date, time.Now())
+	f.dateEnd = resolveDate(f.dateEnd, time.Now())
+	if f.date != "" {
+		_, _, err = parseDateTime(f.date)
+		if err != nil {
+			fmt.Printf("Invalid date format: %v, expecting YYYY-MM-DD or YYYY-MM-DD HH:MM.\nerr:%v\n\n", f.date, err)
+			flagset.Usage()
+		}
 	}
-	_, err = time.Parse("2006-01-02", f.date)
-	if err != nil {
-		fmt.Printf("Invalid date format: %v, expecting YYYY-MM-DD.\nerr:%v\n\n", f.date, err)
+	if f.dateEnd != "" {
+		_, err = time.Parse("2006-01-02", f.dateEnd)
+		if err != nil {
+			fmt.Printf("Invalid date format: %v, expecting YYYY-MM-DD.\nerr:%v\n\n", f.dateEnd, err)
+			flagset.Usage()
+		}
+	}
+
+	if (f.minCost.set || f.maxCost.set) && !f.list.set {
+		fmt.Printf("The -min and -max amounts filter the -l listing, add -l.\n\n")
+		flagset.Usage()
+	}
+	if f.minCost.set && f.maxCost.set && f.minCost.amount > f.maxCost.amount {
+		fmt.Printf("Invalid amount range: -min %v is over -max %v.\n\n", f.minCost.amount, f.maxCost.amount)
+		flagset.Usage()
+	}
+
+	if f.database != "" && f.ledger != "" {
+		fmt.Printf("Only one of -db or -ledger can be given.\n\n")
 		flagset.Usage()
 	}
 
 	a := arguments{}
-	args := flagset.Args()
+	if f.yeet.set && len(args) > 0 { // allow "-yeet db" besides "-yeet=db"
+		err = f.yeet.Set(args[0])
+		if err != nil {
+			fmt.Printf("Invalid yeet target: %v.\nerr:%v\n\n", args[0], err)
+			flagset.Usage()
+		}
+		args = args[1:]
+	}
+	if f.recur != "" { // the arguments describe the recurring transaction, e.g. -recur add 850 rent monthly
+		f.recurSpec = strings.Join(args, " ")
+		args = nil
+	}
+	if f.stats.bare && len(args) > 0 { // allow "-w monthly" besides "-w=monthly"
+		f.stats.window, args = args[0], args[1:]
+	}
+	if f.list.set && len(args) > 0 { // allow "-l 100" besides "-l=100"
+		err = f.list.Set(args[0])
+		if err != nil {
+			fmt.Printf("Invalid list limit: %v, expecting a positive number.\nerr:%v\n\n", args[0], err)
+			flagset.Usage()
+		}
+		args = args[1:]
+	}
 	slog.Debug("Parsing arguments...", "args", args)
 	// liet <cost> [<category>] [<flags>]
 	if len(args) > 0 {
 		var err error
-		a.cost, err = strconv.ParseFloat(args[0], 64)
+		a.cost, err = parseAmount(args[0])
+		a.costSet = true
 		if err != nil {
 			fmt.Printf("Invalid cost value: %v, expecting a number.\nerr:%v\n\n", args[0], err)
 			flagset.Usage()
@@ -184,7 +823,21 @@ Normal values can be: "last week", "last month", "all time" or "today". For an e
 	if len(args) > 1 {
 		a.category = args[1]
 	}
-
+	if f.currency != "" && !a.costSet {
+		fmt.Printf("The -cur currency is the one of a new transaction, add its cost.\n\n")
+		flagset.Usage()
+	}
+	if f.receipt != "" && !a.costSet {
+		fmt.Printf("The -r receipt is attached to a new transaction, add its cost.\n\n")
+		flagset.Usage()
+	}
+	if f.split.set && (!a.costSet || a.category != "" || f.edit != 0) {
+		fmt.Printf("The -split parts have the categories of a new transaction, give only its cost.\n\n")
+		flagset.Usage()
+	}
+	if f.date == "" && (a.costSet || f.batch != "") && f.edit == 0 { // only new transactions default to today
+		f.date = time.Now().Format("2006-01-02")
+	}
 	slog.Debug("Parsed arguments", "arguments", a, "flags", f)
 
 	return a, f
@@ -195,223 +848,2175 @@ const (
 )
 
 type userConfig struct {
-	databasePath string
+	databasePath    string
+	weekStart       time.Weekday
+	budgets         map[string]cents // monthly limit per category
+	currency        string
+	numbers         numberFormat
+	decimals        int     // shown of the amounts, 2 unless the currency has no cents
+	defaultCategory string  // of the new transactions given without one
+	defaultStats    string  // the stats view of a bare -w
+	reportCurrency  string  // of the second cost column of the category stats
+	reportRate      float64 // of the report currency to the currency, no second cost column when 0
+	baseCurrency    string  // ISO 4217 code of the currency of the transactions given without one
+	dateFormat      string  // Go layout of the dates shown, YYYY-MM-DD when empty, the stored and exported ones stay so
+	encrypted       bool    // the database file is encrypted with a passphrase
+	passphrase      string  // of the encrypted database, asked on every run
 }
 
 func loadUserConfig() (userConfig, error) {
 	configPath := os.Getenv(configFileEnv)
-	u := userConfig{}
+	u := userConfig{weekStart: time.Monday, decimals: centsDigits}
 
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return u, fmt.Errorf("failed to get home directory: %w", err)
 	}
+	u.databasePath = userPath(homeDir, defaultDatabaseFile)
 
 	if configPath == "" {
-		configPath = filepath.Join(homeDir, defaultConfigFile)
+		configPath = userPath(homeDir, defaultConfigFile)
 		slog.Debug("No config file specified, using default location", "path", configPath)
 	}
 	b, err := os.ReadFile(filepath.Clean(configPath))
 	if errors.Is(err, os.ErrNotExist) {
-		u = userConfig{
-			databasePath: filepath.Join(homeDir, defaultDatabaseFile),
-		}
-		slog.Debug("No config file found, using default database config", "path", filepath.Join(homeDir, defaultDatabaseFile))
+		slog.Debug("No config file found, using default database config", "path", userPath(homeDir, defaultDatabaseFile))
 		return u, nil
 	}
 	if err != nil {
 		return u, fmt.Errorf("failed to read config file %q: %w", configPath, err)
 	}
-	lines := strings.Split(string(b), "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
-			continue // skip empty lines and comments
+	parseConfig := parseKeyValueConfig
+	if strings.EqualFold(filepath.Ext(configPath), ".toml") {
+		parseConfig = parseTOMLConfig
+	}
+	entries, err := parseConfig(b)
+	if err != nil {
+		return u, fmt.Errorf("%w in config file %q", err, configPath)
+	}
+	unknownSections := map[string]bool{}
+	for _, e := range entries {
+		if e.section != "" && e.section != "budgets" { // e.g. a typo of [budgets], its keys are not top level ones
+			if !unknownSections[e.section] {
+				slog.Warn("Unknown config section, its keys are ignored", "section", e.section, "line", e.line, "path", configPath)
+			}
+			unknownSections[e.section] = true
+			continue
 		}
-
-		parts := strings.SplitN(line, "=", keyValuePairs)
-		switch parts[0] {
+		if e.section == "budgets" {
+			category := e.key
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing budget for category %q in config file %q", errUser, category, configPath)
+			}
+			limit, err := parseCents(e.value)
+			if err != nil || limit <= 0 {
+				return u, fmt.Errorf(
+					"%w: invalid budget %q for category %q in config file %q", errUser, strings.TrimSpace(e.value), category, configPath,
+				)
+			}
+			if u.budgets == nil {
+				u.budgets = map[string]cents{}
+			}
+			u.budgets[category] = limit
+			continue
+		}
+		switch e.key {
 		case "database":
-			if len(parts) < keyValuePairs {
+			if !e.hasValue {
 				return u, fmt.Errorf("%w: missing value for 'database' in config file %q", errUser, configPath)
 			}
-			databasePath := strings.TrimSpace(parts[1])
+			databasePath := strings.TrimSpace(e.value)
 			if databasePath == "" {
 				return u, fmt.Errorf("%w: empty value for 'database' in config file %q", errUser, configPath)
 			}
 			u.databasePath = databasePath
+		case "currency":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'currency' in config file %q", errUser, configPath)
+			}
+			u.currency = strings.TrimSpace(e.value)
+		case "number_format":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'number_format' in config file %q", errUser, configPath)
+			}
+			u.numbers, err = parseNumberFormat(e.value)
+			if err != nil {
+				return u, fmt.Errorf("%w in config file %q", err, configPath)
+			}
+		case "decimals":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'decimals' in config file %q", errUser, configPath)
+			}
+			u.decimals, err = parseDecimals(e.value)
+			if err != nil {
+				return u, fmt.Errorf("%w in config file %q", err, configPath)
+			}
+		case "week_start":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'week_start' in config file %q", errUser, configPath)
+			}
+			switch weekStart := strings.ToLower(strings.TrimSpace(e.value)); weekStart {
+			case "monday":
+				u.weekStart = time.Monday
+			case "sunday":
+				u.weekStart = time.Sunday
+			default:
+				return u, fmt.Errorf(
+					"%w: invalid value %q for 'week_start' in config file %q, expecting monday or sunday", errUser, weekStart, configPath,
+				)
+			}
+		case "base_currency":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'base_currency' in config file %q", errUser, configPath)
+			}
+			u.baseCurrency, err = parseCurrencyCode(e.value)
+			if err != nil {
+				return u, fmt.Errorf("%w in config file %q", err, configPath)
+			}
+		case "report_currency":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'report_currency' in config file %q", errUser, configPath)
+			}
+			u.reportCurrency = strings.TrimSpace(e.value)
+		case "report_rate":
+			u.reportRate, err = strconv.ParseFloat(strings.TrimSpace(e.value), 64)
+			if !e.hasValue || err != nil || u.reportRate <= 0 || math.IsInf(u.reportRate, 0) {
+				return u, fmt.Errorf(
+					"%w: invalid value %q for 'report_rate' in config file %q, expecting a positive rate, e.g. 1.08", errUser, e.value, configPath,
+				)
+			}
+		case "default_stats":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'default_stats' in config file %q", errUser, configPath)
+			}
+			defaultStats := strings.TrimSpace(e.value)
+			if !knownStats(defaultStats) {
+				slog.Warn("Unknown default_stats view, a bare -w shows this month instead", "value", defaultStats, "line", e.line, "path", configPath)
+				continue
+			}
+			u.defaultStats = defaultStats
+		case "display_date_format":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'display_date_format' in config file %q", errUser, configPath)
+			}
+			u.dateFormat, err = parseDateLayout(e.value)
+			if err != nil {
+				return u, fmt.Errorf("%w in config file %q", err, configPath)
+			}
+		case "default_category":
+			if !e.hasValue {
+				return u, fmt.Errorf("%w: missing value for 'default_category' in config file %q", errUser, configPath)
+			}
+			u.defaultCategory = strings.TrimSpace(e.value)
+		case "encrypted":
+			u.encrypted, err = strconv.ParseBool(strings.TrimSpace(e.value))
+			if !e.hasValue || err != nil {
+				return u, fmt.Errorf("%w: invalid value %q for 'encrypted' in config file %q, expecting true or false", errUser, e.value, configPath)
+			}
 		default:
+			slog.Warn("Unknown config key, it is ignored",
+				"key", e.key, "value", strings.TrimSpace(e.value), "section", e.section, "line", e.line, "path", configPath)
 		}
 	}
 
+	u.numbers.dropped = centsDigits - u.decimals // number_format can come after decimals
 	return u, nil
 }
 
-type database interface {
-	Query(query string, args ...any) (*sql.Rows, error)
-	Exec(query string, args ...any) (sql.Result, error)
+// configEntry is a key value pair of the config file and the section it is in, e.g. the budgets.
+type configEntry struct {
+	section  string
+	key      string
+	value    string
+	hasValue bool
+	line     int
 }
 
-func dbInit(db database) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS transactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			cost REAL NOT NULL,
-			category TEXT,
-			comment TEXT,
-			date TEXT NOT NULL
-	);
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
+// parseKeyValueConfig parses the legacy config format, key=value lines with optional [section] headers.
+func parseKeyValueConfig(b []byte) ([]configEntry, error) {
+	var (
+		entries []configEntry
+		section string
+	)
+	for i, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue // skip empty lines and comments
+		}
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", keyValuePairs)
+		e := configEntry{section: section, key: strings.TrimSpace(parts[0]), line: i + 1}
+		if len(parts) == keyValuePairs {
+			e.value, e.hasValue = parts[1], true
+		}
+		entries = append(entries, e)
 	}
-	return nil
+	return entries, nil
 }
 
-func insertTransaction(db database, cost float64, category, comment, date string) error {
-	query := "INSERT INTO transactions (cost, category, comment, date) VALUES (?, ?, ?, ?)"
-	categoryPtr := sql.NullString{String: category, Valid: strings.TrimSpace(category) != ""}
-	_, err := db.Exec(query, cost, categoryPtr, comment, date)
+// ledgerPath returns the database of a named ledger, e.g. work is ~/.local/share/liet/work.db on linux.
+func ledgerPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%w: invalid ledger name %q", errUser, name)
+	}
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("failed to insert transaction: %w", err)
+		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
-	return nil
+	return filepath.Join(userPath(homeDir, defaultLedgersDir), name+".db"), nil
 }
 
-func dbExport(db database, filePath string) error {
-	rows, err := db.Query("SELECT * FROM transactions")
+// listLedgers prints the names of the ledgers, i.e. the databases in the ledgers directory.
+func listLedgers() error {
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("failed to query transactions: %w", err)
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	dir := userPath(homeDir, defaultLedgersDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read ledgers directory %q: %w", dir, err)
+	}
+
+	var ledgers []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".db")
+		if ok && entry.Type().IsRegular() {
+			ledgers = append(ledgers, name)
+		}
+	}
+	if len(ledgers) == 0 {
+		fmt.Printf("No ledgers yet in %s, create one with -ledger <name>.\n", dir)
+		return nil
+	}
+	for _, name := range ledgers {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// checkSetup reports whether the config file and the database are usable, without recording anything.
+func checkSetup(c userConfig, configErr error) error {
+	var problems int
+	report := func(what, status string, err error) {
+		if err != nil {
+			problems++
+			status = "PROBLEM, " + strings.TrimPrefix(err.Error(), errUser.Error()+": ")
+		}
+		fmt.Printf("%-14s%s\n", what+":", status)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+	configPath := os.Getenv(configFileEnv)
+	if configPath == "" {
+		configPath = userPath(homeDir, defaultConfigFile)
+	}
+	status := configPath
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		status += " (not found, using the defaults)"
+	}
+	report("Config", status, configErr)
+
+	report("Database", c.databasePath, nil)
+	dir := filepath.Dir(c.databasePath)
+	err = os.MkdirAll(dir, 0o700) //nolint:mnd // reasonable dir permissions
+	if err == nil {
+		var probe *os.File
+		probe, err = os.CreateTemp(dir, ".liet-check-*")
+		if err == nil {
+			_ = probe.Close()
+			err = os.Remove(probe.Name())
+		}
+	}
+	report("Directory", dir+" (writable)", err)
+	if err != nil {
+		return fmt.Errorf("%w: found %d problem(s)", errUser, problems)
+	}
+
+	databasePath := c.databasePath
+	if c.encrypted {
+		var seal func() error
+		databasePath, seal, err = decryptDatabase(c.databasePath, c.passphrase, false)
+		report("Encryption", "on", err)
+		if err != nil {
+			return fmt.Errorf("%w: found %d problem(s)", errUser, problems)
+		}
+		defer reseal(seal)
+	}
+	db, err := sql.Open("sqlite", databaseDSN(databasePath, false))
+	if err == nil {
+		defer handleErrClose(db.Close)
+		err = dbInit(db)
+	}
+	var count int
+	if err == nil {
+		err = db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&count)
+	}
+	report("Transactions", strconv.Itoa(count), err)
+
+	if problems > 0 {
+		return fmt.Errorf("%w: found %d problem(s)", errUser, problems)
+	}
+	fmt.Println("All good!")
+	return nil
+}
+
+// resolveDatabasePath picks the database by precedence: the -db flag, the LIET_DATABASE env var and then the
+// configured one, which is either the config file database or the default.
+func resolveDatabasePath(configured, flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if envPath := os.Getenv(databaseEnv); envPath != "" {
+		return envPath
+	}
+	return configured
+}
+
+type database interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	Exec(query string, args ...any) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// schemaVersion is the PRAGMA user_version of an up to date database, bump it with every change to dbInit.
+const schemaVersion = 4
+
+// dbInit creates or migrates the database schema, unless its version tells it is up to date.
+func dbInit(db *sql.DB) error {
+	version, err := userVersion(db)
+	if err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			cost INTEGER NOT NULL, -- in cents
+			category TEXT,
+			comment TEXT,
+			date TEXT NOT NULL,
+			time TEXT, -- HH:MM, when known
+			receipt TEXT, -- path of the receipt file, when attached
+			currency TEXT -- ISO 4217 code, the base currency when NULL
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	err = migrateCostToCents(db)
+	if err != nil {
+		return err
+	}
+	err = addTransactionsColumn(db, "time", "Adding the time of day to transactions")
+	if err != nil {
+		return err
+	}
+	err = addTransactionsColumn(db, "receipt", "Adding the receipts to transactions")
+	if err != nil {
+		return err
+	}
+	err = addTransactionsColumn(db, "currency", "Adding the currency to transactions")
+	if err != nil {
+		return err
+	}
+	tagType, err := columnType(db, "tags", "tag")
+	if err != nil {
+		return err
+	}
+	// indexes and triggers only after migrating, since the migration re-creates the table
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_transactions_date ON transactions(date);
+		CREATE INDEX IF NOT EXISTS idx_transactions_category ON transactions(category);
+		CREATE TABLE IF NOT EXISTS tags (
+			transaction_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (transaction_id, tag)
+		);
+		CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag);
+		CREATE TABLE IF NOT EXISTS recurring (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			cost INTEGER NOT NULL, -- in cents
+			category TEXT,
+			comment TEXT,
+			cadence TEXT NOT NULL,
+			start_date TEXT NOT NULL,
+			last_applied TEXT
+		);
+		CREATE TABLE IF NOT EXISTS archived_transactions (
+			id INTEGER PRIMARY KEY, -- kept from transactions, so the tags still apply
+			cost INTEGER NOT NULL, -- in cents
+			category TEXT,
+			comment TEXT,
+			date TEXT NOT NULL,
+			time TEXT,
+			receipt TEXT,
+			currency TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_archived_transactions_date ON archived_transactions(date);
+		-- the tags of archived transactions stay, they go with the archived transaction
+		DROP TRIGGER IF EXISTS delete_transaction_tags;
+		CREATE TRIGGER delete_transaction_tags AFTER DELETE ON transactions
+		WHEN OLD.id NOT IN (SELECT id FROM archived_transactions) BEGIN
+			DELETE FROM tags WHERE transaction_id = OLD.id;
+		END;
+		CREATE TRIGGER IF NOT EXISTS delete_archived_transaction_tags AFTER DELETE ON archived_transactions BEGIN
+			DELETE FROM tags WHERE transaction_id = OLD.id;
+		END;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create database indexes and tags: %w", err)
+	}
+	if tagType == "" { // the tags table is new, tag the transactions recorded before it
+		err = tagExisting(db)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)) // no placeholders in pragmas
+	if err != nil {
+		return fmt.Errorf("failed to set database schema version: %w", err)
+	}
+	return nil
+}
+
+func userVersion(db database) (int, error) {
+	rows, err := db.Query("PRAGMA user_version")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database schema version: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var version int
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return 0, fmt.Errorf("failed to scan database schema version: %w", err)
+		}
+	}
+	if rows.Err() != nil {
+		return 0, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return version, nil
+}
+
+func tagExisting(db database) error {
+	rows, err := db.Query("SELECT id, comment FROM transactions WHERE comment LIKE '%#%'")
+	if err != nil {
+		return fmt.Errorf("failed to query tagged comments: %w", err)
+	}
+	comments := map[int64]string{}
+	for rows.Next() {
+		var (
+			id      int64
+			comment string
+		)
+		if err = rows.Scan(&id, &comment); err != nil {
+			break
+		}
+		comments[id] = comment
+	}
+	handleErrClose(rows.Close) // before writing the tags
+	if err != nil {
+		return fmt.Errorf("failed to scan tagged comment: %w", err)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	for id, comment := range comments {
+		err = tagTransaction(db, id, comment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCostToCents converts databases created when the cost was stored as a REAL into integer cents.
+func migrateCostToCents(db *sql.DB) error {
+	costType, err := columnType(db, "transactions", "cost")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(costType, "REAL") {
+		return nil
+	}
+
+	slog.Info("Migrating transaction costs to cents")
+	return withTx(db, func(tx database) error {
+		for _, statement := range []string{
+			`CREATE TABLE transactions_cents (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				cost INTEGER NOT NULL, -- in cents
+				category TEXT,
+				comment TEXT,
+				date TEXT NOT NULL
+			)`,
+			`INSERT INTO transactions_cents (id, cost, category, comment, date)
+				SELECT id, CAST(ROUND(cost * 100) AS INTEGER), category, comment, date FROM transactions`,
+			"DROP TABLE transactions",
+			"ALTER TABLE transactions_cents RENAME TO transactions",
+		} {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to migrate costs to cents: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// addTransactionsColumn adds a TEXT column to databases created before it, e.g. the time of day, their transactions
+// have none.
+func addTransactionsColumn(db database, column, message string) error {
+	columnT, err := columnType(db, "transactions", column)
+	if err != nil {
+		return err
+	}
+	if columnT != "" {
+		return nil
+	}
+
+	slog.Info(message)
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN " + column + " TEXT")
+	if err != nil {
+		return fmt.Errorf("failed to add the %s column: %w", column, err)
+	}
+	return nil
+}
+
+// columnType returns the declared type of a table column, or an empty string if the column does not exist.
+func columnType(db database, tableName, column string) (string, error) {
+	rows, err := db.Query("SELECT type FROM pragma_table_info(?) WHERE name = ?", tableName, column)
+	if err != nil {
+		return "", fmt.Errorf("failed to query %s.%s column type: %w", tableName, column, err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var t string
+	if rows.Next() {
+		if err := rows.Scan(&t); err != nil {
+			return "", fmt.Errorf("failed to scan %s.%s column type: %w", tableName, column, err)
+		}
+	}
+	if rows.Err() != nil {
+		return "", fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return t, nil
+}
+
+// cents is a monetary amount, money is always handled as an integer number of cents to avoid floating point errors.
+type cents int64
+
+const (
+	centsPerUnit = 100
+	centsDigits  = 2 // the decimals stored
+)
+
+func parseCents(s string) (cents, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse amount %q: %w", s, err)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f*centsPerUnit) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: amount %q is out of range", errUser, s)
+	}
+	return cents(math.Round(f * centsPerUnit)), nil
+}
+
+// parseAmount parses amounts as written by humans, e.g. copied from a receipt, such as $1,234.50 or €9,99.
+// A single comma followed by exactly three digits is ambiguous and taken as a thousands separator, e.g. 1,234 is 1234.
+func parseAmount(s string) (cents, error) {
+	amount := strings.TrimSpace(s)
+	sign := ""
+	if rest, ok := strings.CutPrefix(amount, "-"); ok {
+		sign, amount = "-", rest
+	}
+	isNumeric := func(r rune) bool { return unicode.IsDigit(r) || r == '.' || r == ',' }
+	amount = strings.TrimFunc(amount, func(r rune) bool { return !isNumeric(r) }) // currency symbols
+	amount = strings.NewReplacer(" ", "", "'", "", "\u00a0", "").Replace(amount)
+
+	lastComma, lastDot := strings.LastIndex(amount, ","), strings.LastIndex(amount, ".")
+	switch {
+	case lastComma >= 0 && lastDot >= 0: // the last separator is the decimal one
+		if lastComma > lastDot {
+			amount = strings.ReplaceAll(amount, ".", "")
+			amount = strings.Replace(amount, ",", ".", 1)
+		} else {
+			amount = strings.ReplaceAll(amount, ",", "")
+		}
+	case strings.Count(amount, ",") == 1 && len(amount)-lastComma-1 != 3: //nolint:mnd // digits of a thousands group
+		amount = strings.Replace(amount, ",", ".", 1)
+	case strings.Count(amount, ".") > 1:
+		amount = strings.ReplaceAll(amount, ".", "")
+	default:
+		amount = strings.ReplaceAll(amount, ",", "")
+	}
+
+	c, err := parseCents(sign + amount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse amount %q: %w", s, err)
+	}
+	return c, nil
+}
+
+func (c cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/centsPerUnit, c%centsPerUnit)
+}
+
+// round splits the amount rounded to the decimals, 0 to 2, into its sign, units and decimals, e.g. -, 1234 and 6 for
+// -1234.56 with one decimal.
+func (c cents) round(decimals int) (sign, units, fraction string) {
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	step := cents(math.Pow10(centsDigits - decimals))
+	c = (c + step/2) / step * step //nolint:mnd // half away from zero
+	if c == 0 {
+		sign = ""
+	}
+	if decimals > 0 {
+		fraction = fmt.Sprintf("%02d", c%centsPerUnit)[:decimals]
+	}
+	return sign, strconv.FormatInt(int64(c/centsPerUnit), 10), fraction
+}
+
+// parseDecimals reads the decimals shown of the amounts, 0 for currencies without cents such as JPY.
+func parseDecimals(s string) (int, error) {
+	decimals, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || decimals < 0 || decimals > centsDigits {
+		return 0, fmt.Errorf("%w: invalid decimals %q, expecting 0, 1 or 2", errUser, strings.TrimSpace(s))
+	}
+	return decimals, nil
+}
+
+// numberFormat are the separators used to display amounts, the zero value is the plain 1234567.89.
+type numberFormat struct {
+	group   string
+	decimal string
+	dropped int // decimals rounded away, 2 shows whole units
+}
+
+// parseNumberFormat reads the separators from how 1234.56 is written, e.g. 1,234.56, 1.234,56 or 1 234,56.
+func parseNumberFormat(example string) (numberFormat, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(example), "1")
+	group, rest, found := strings.Cut(rest, "234")
+	decimal, hasCents := strings.CutSuffix(rest, "56")
+	if !ok || !found || !hasCents || utf8.RuneCountInString(group) > 1 || utf8.RuneCountInString(decimal) != 1 ||
+		group == decimal || strings.ContainsAny(group+decimal, "0123456789-") {
+		return numberFormat{}, fmt.Errorf("%w: invalid number format %q, expecting how 1234.56 is written, e.g. 1,234.56", errUser, example)
+	}
+	return numberFormat{group: group, decimal: decimal}, nil
+}
+
+// format writes the amount with the separators, e.g. 1,234,567.89.
+func (n numberFormat) format(c cents) string {
+	sign, units, fraction := c.round(centsDigits - n.dropped)
+	if n.group != "" {
+		b := strings.Builder{}
+		for i, digit := range units {
+			if i > 0 && (len(units)-i)%3 == 0 {
+				b.WriteString(n.group)
+			}
+			b.WriteRune(digit)
+		}
+		units = b.String()
+	}
+	if fraction == "" {
+		return sign + units
+	}
+	return sign + units + cmp.Or(n.decimal, ".") + fraction
+}
+
+const insertTransactionQuery = `INSERT INTO transactions (cost, category, comment, date, time, receipt, currency)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+// newTransaction returns a transaction to insert, the date can have a time of day, e.g. 2023-10-01 14:30.
+func newTransaction(cost cents, category, comment, date string) transaction {
+	return transaction{
+		cost: cost, category: sql.NullString{String: category, Valid: strings.TrimSpace(category) != ""}, comment: comment, date: date,
+	}
+}
+
+// insertArgs are the values of the insertTransactionQuery placeholders.
+func (t transaction) insertArgs() []any {
+	date, clock, _ := strings.Cut(t.date, " ")
+	return []any{
+		t.cost, t.category, t.comment, date, sql.NullString{String: clock, Valid: clock != ""},
+		sql.NullString{String: t.receipt, Valid: t.receipt != ""}, sql.NullString{String: t.currency, Valid: t.currency != ""},
+	}
+}
+
+// insertTransaction adds a transaction, returning its id.
+func insertTransaction(db database, cost cents, category, comment, date string) (int64, error) {
+	return newTransaction(cost, category, comment, date).insert(db)
+}
+
+// insert adds the transaction, e.g. with its receipt, returning its id.
+func (t transaction) insert(db database) (int64, error) {
+	res, err := db.Exec(insertTransactionQuery, t.insertArgs()...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert transaction: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted transaction id: %w", err)
+	}
+	err = tagTransaction(db, id, t.comment)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertTransactions adds the transactions with a single prepared statement, much faster than insertTransaction for
+// many of them, e.g. of an import.
+func insertTransactions(ctx context.Context, db database, transactions []transaction) error {
+	stmt, err := db.Prepare(insertTransactionQuery)
+	if err != nil {
+		return fmt.Errorf("failed to prepare transaction insert: %w", err)
+	}
+	defer handleErrClose(stmt.Close)
+
+	for _, t := range transactions {
+		res, err := stmt.ExecContext(ctx, t.insertArgs()...)
+		if err != nil {
+			return fmt.Errorf("failed to insert transaction: %w", err)
+		}
+		if len(extractTags(t.comment)) == 0 { // a new transaction has no tags to clear
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get inserted transaction id: %w", err)
+		}
+		err = tagTransaction(db, id, t.comment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTags returns the #hashtags of a comment, lower cased and without duplicates, e.g. "#Trip to #Rome!" has
+// the tags trip and rome.
+func extractTags(comment string) []string {
+	var tags []string
+	for word := range strings.FieldsSeq(comment) {
+		tag, ok := strings.CutPrefix(word, "#")
+		if !ok {
+			continue
+		}
+		tag = normalizeTag(tag)
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// normalizeTag lower cases the tag and drops anything after its letters, digits, - and _, e.g. punctuation.
+func normalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	end := strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' })
+	if end != -1 {
+		tag = tag[:end]
+	}
+	return tag
+}
+
+// tagTransaction replaces the tags of a transaction with the ones in its comment.
+func tagTransaction(db database, id int64, comment string) error {
+	_, err := db.Exec("DELETE FROM tags WHERE transaction_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to clear transaction tags: %w", err)
+	}
+	for _, tag := range extractTags(comment) {
+		_, err = db.Exec("INSERT INTO tags (transaction_id, tag) VALUES (?, ?)", id, tag)
+		if err != nil {
+			return fmt.Errorf("failed to tag transaction: %w", err)
+		}
+	}
+	return nil
+}
+
+type transaction struct {
+	id       int
+	cost     cents
+	category sql.NullString
+	comment  string
+	date     string
+	receipt  string // path of the receipt file, when attached
+	currency string // ISO 4217 code, empty for the base currency
+}
+
+func (t transaction) String() string {
+	category := "N/A"
+	if t.category.Valid {
+		category = t.category.String
+	}
+	s := fmt.Sprintf("#%d: %v %s on %s", t.id, t.cost, category, t.date)
+	if t.comment != "" {
+		s += fmt.Sprintf(" (%s)", t.comment)
+	}
+	return s
+}
+
+// getTransaction returns the transaction with the id, whether recent or archived.
+func getTransaction(db database, id int) (transaction, error) {
+	t := transaction{}
+	rows, err := db.Query(
+		"SELECT id, cost, category, COALESCE(comment, ''), "+dateTime+", COALESCE(receipt, ''), COALESCE(currency, '') "+
+			"FROM "+allTransactions+" WHERE id = ?", id,
+	)
+	if err != nil {
+		return t, fmt.Errorf("failed to query transaction: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return t, fmt.Errorf("error iterating over rows: %w", rows.Err())
+		}
+		return t, fmt.Errorf("%w: no transaction with id %d", errUser, id)
+	}
+	if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date, &t.receipt, &t.currency); err != nil {
+		return t, fmt.Errorf("failed to scan row: %w", err)
+	}
+	return t, nil
+}
+
+// parseCurrencyCode reads an ISO 4217 currency code, e.g. USD.
+func parseCurrencyCode(s string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	if len(code) != 3 || strings.ContainsFunc(code, func(r rune) bool { return r < 'A' || r > 'Z' }) { //nolint:mnd // ISO 4217
+		return "", fmt.Errorf("%w: invalid currency %q, expecting an ISO 4217 code, e.g. USD", errUser, strings.TrimSpace(s))
+	}
+	return code, nil
+}
+
+// costWithCurrency writes a cost followed by the code of its currency, unless it is in the base currency.
+func costWithCurrency(c cents, currency string) string {
+	return strings.TrimSpace(c.String() + " " + currency)
+}
+
+// receiptPath returns the absolute path of a receipt file, so that it opens from any directory later, checking that
+// the file exists.
+func receiptPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the receipt path %q: %w", path, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("%w: no receipt file at %q", errUser, path)
+	}
+	return abs, nil
+}
+
+// openReceipt opens the receipt of a transaction with the default application of its file type.
+func openReceipt(db database, id int) error {
+	t, err := getTransaction(db, id)
+	if err != nil {
+		return err
+	}
+	if t.receipt == "" {
+		return fmt.Errorf("%w: transaction #%d has no receipt, attach one with -r when adding it", errUser, id)
+	}
+	if _, err := os.Stat(t.receipt); err != nil {
+		return fmt.Errorf("%w: the receipt of transaction #%d is no longer at %q", errUser, id, t.receipt)
+	}
+	fmt.Printf("Opening %s\n", t.receipt)
+	return openFile(t.receipt)
+}
+
+func deleteTransaction(db database, id int) error {
+	t, err := getTransaction(db, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := execTransaction(db, "DELETE FROM %s WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete transaction: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: no transaction with id %d", errUser, id)
+	}
+	fmt.Printf("Removed transaction %v\n", t)
+	return nil
+}
+
+func undoLast(db database) error {
+	rows, err := db.Query("SELECT MAX(id) FROM transactions")
+	if err != nil {
+		return fmt.Errorf("failed to query last transaction: %w", err)
+	}
+	var id sql.NullInt64
+	if rows.Next() {
+		err = rows.Scan(&id)
+	}
+	handleErrClose(rows.Close)
+	if err != nil {
+		return fmt.Errorf("failed to scan last transaction: %w", err)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	if !id.Valid {
+		fmt.Println("Nothing to undo.")
+		return nil
+	}
+	return deleteTransaction(db, int(id.Int64))
+}
+
+// transactionTables are the tables holding transactions, the recent ones first.
+var transactionTables = []string{"transactions", "archived_transactions"}
+
+// execTransaction runs the statement, with %s standing for the table, on the recent transactions and then on the
+// archived ones until some row is affected, returning how many were. Ids are unique across both tables.
+func execTransaction(db database, query string, args ...any) (int64, error) {
+	for _, table := range transactionTables {
+		res, err := db.Exec(fmt.Sprintf(query, table), args...) //nolint:gosec // the tables come from transactionTables
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// moveCategory sets the category of all the transactions of the old category to the new one, archived ones included,
+// returning how many moved.
+func moveCategory(db database, old, new string) (int64, error) {
+	category := sql.NullString{String: new, Valid: strings.TrimSpace(new) != ""}
+	var moved int64
+	for _, table := range transactionTables {
+		res, err := db.Exec("UPDATE "+table+" SET category = ? WHERE category = ?", category, old)
+		if err != nil {
+			return 0, fmt.Errorf("failed to move category %q: %w", old, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		moved += n
+	}
+	return moved, nil
+}
+
+// renameCategory moves all the transactions of a category to another one at once, an empty new category removes it.
+func renameCategory(db *sql.DB, old, new string) error {
+	var n int64
+	err := withTx(db, func(tx database) error {
+		var err error
+		n, err = moveCategory(tx, old, new)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(new) == "" {
+		new = "N/A"
+	}
+	fmt.Printf("Renamed %q to %q in %d transaction(s)\n", old, new, n)
+	return nil
+}
+
+// mergeCategories moves all the transactions of the sources to the target category at once, merging the target into
+// itself is a no-op.
+func mergeCategories(db *sql.DB, sources []string, target string) error {
+	var total int64
+	err := withTx(db, func(tx database) error {
+		for _, source := range sources {
+			if source == target {
+				continue
+			}
+			n, err := moveCategory(tx, source, target)
+			if err != nil {
+				return err
+			}
+			total += n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %s into %q, %d transaction(s) moved\n", strings.Join(sources, ", "), target, total)
+	return nil
+}
+
+// listCategories prints all the categories in use and how many transactions each has, N/A goes last.
+func listCategories(db database) error {
+	rows, err := db.Query(`
+SELECT
+    category, COUNT(*)
+FROM
+    ` + allTransactions + `
+GROUP BY
+    category
+ORDER BY
+    category IS NULL, category;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query categories: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	out := table{headers: []string{"Category", "Transactions"}}
+	for rows.Next() {
+		var (
+			category sql.NullString
+			count    int
+		)
+		if err := rows.Scan(&category, &count); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		name := "N/A"
+		if category.Valid {
+			name = category.String
+		}
+		out.rows = append(out.rows, []string{name, strconv.Itoa(count)})
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	if len(out.rows) == 0 {
+		fmt.Println("No categories yet.")
+		return nil
+	}
+	out.print()
+	return nil
+}
+
+// backupDatabase writes a consistent copy of the database to dst, when dst is a directory the copy is named after
+// today, e.g. liet-backup-2023-10-01.db.
+func backupDatabase(db database, dst, passphrase string) error {
+	info, err := os.Stat(dst)
+	if err == nil && info.IsDir() {
+		dst = filepath.Join(dst, "liet-backup-"+time.Now().Format("2006-01-02")+".db")
+		_, err = os.Stat(dst)
+	}
+	if err == nil {
+		return fmt.Errorf("%w: backup file %q already exists", errUser, dst)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check backup file %q: %w", dst, err)
+	}
+
+	_, err = db.Exec("VACUUM INTO ?", dst)
+	if err != nil {
+		return fmt.Errorf("failed to backup database to %q: %w", dst, err)
+	}
+	if passphrase != "" { // the backup of an encrypted database is encrypted too
+		err = encryptWithPassphrase(dst, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Database backed up to %s\n", dst)
+	return nil
+}
+
+// compactDatabase rebuilds the database to reclaim the space left by removed transactions.
+func compactDatabase(db database) error {
+	before, err := databaseSize(db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("VACUUM")
+	if err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	// in WAL mode the vacuum goes through the journal, only a checkpoint shrinks it back
+	_, err = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	after, err := databaseSize(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database compacted from %s to %s\n", byteSize(before), byteSize(after))
+	return nil
+}
+
+func databaseSize(db database) (int64, error) {
+	rows, err := db.Query("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var size int64
+	if rows.Next() {
+		err = rows.Scan(&size)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan database size: %w", err)
+		}
+	}
+	if rows.Err() != nil {
+		return 0, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return size, nil
+}
+
+// byteSize formats a size in bytes with a binary unit, e.g. 1.5 KiB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, prefix := float64(n)/unit, 0
+	for size >= unit && prefix < len("KMGT")-1 {
+		size /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGT"[prefix])
+}
+
+// parseDateLayout reads a Go reference time layout of the dates shown, e.g. 02/01/2006, which must show at least the
+// day, the month or the year.
+func parseDateLayout(s string) (string, error) {
+	layout := strings.TrimSpace(s)
+	if day := time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC); day.Format(layout) == day.AddDate(1, 1, 1).Format(layout) {
+		return "", fmt.Errorf("%w: invalid date format %q, expecting a Go layout of the reference date, e.g. 02/01/2006", errUser, layout)
+	}
+	return layout, nil
+}
+
+// displayDate formats a stored date, with its time of day when known, e.g. 2023-10-01 14:30, with the layout given,
+// leaving it as stored without one.
+func displayDate(date, layout string) string {
+	day, clock, hasClock := strings.Cut(date, " ")
+	t, err := time.Parse("2006-01-02", day)
+	if layout == "" || err != nil {
+		return date
+	}
+	if hasClock {
+		return t.Format(layout) + " " + clock
+	}
+	return t.Format(layout)
+}
+
+// transactionColumns are the columns of both the transactions and the archived_transactions tables.
+const transactionColumns = "id, cost, category, comment, date, time, receipt, currency"
+
+// allTransactions selects the recent and the archived transactions together.
+const allTransactions = "(SELECT " + transactionColumns + " FROM transactions " +
+	"UNION ALL SELECT " + transactionColumns + " FROM archived_transactions)"
+
+// transactionsIn returns the table to select the transactions between the dates from: the transactions alone, unless
+// some archived transactions fall between the dates too, so the recent windows never read the archive.
+func transactionsIn(db database, startDate, endDate string) (string, error) {
+	rows, err := db.Query("SELECT 1 FROM archived_transactions WHERE date BETWEEN ? AND ? LIMIT 1", startDate, endDate)
+	if err != nil {
+		return "", fmt.Errorf("failed to query archived transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	archived := rows.Next()
+	if rows.Err() != nil {
+		return "", fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	if !archived {
+		return "transactions", nil
+	}
+	return allTransactions, nil
+}
+
+// archiveTransactions moves the transactions before the date to the archived_transactions table at once, keeping the
+// transactions table small for the recent stats, listings and edits. The stats including older dates still count them.
+func archiveTransactions(db *sql.DB, before string) error {
+	if _, err := time.Parse("2006-01-02", before); err != nil {
+		return fmt.Errorf("%w: invalid archive date %q, expecting YYYY-MM-DD, today, yesterday or N days ago", errUser, before)
+	}
+	var archived int64
+	err := withTx(db, func(tx database) error {
+		_, err := tx.Exec(
+			"INSERT INTO archived_transactions ("+transactionColumns+") SELECT "+transactionColumns+" FROM transactions WHERE date < ?", before,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to archive transactions: %w", err)
+		}
+		res, err := tx.Exec("DELETE FROM transactions WHERE date < ?", before)
+		if err != nil {
+			return fmt.Errorf("failed to delete archived transactions: %w", err)
+		}
+		archived, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Archived %d transaction(s) before %s\n", archived, before)
+	return nil
+}
+
+// dateTime is the date of a transaction followed by its time of day when known, e.g. 2023-10-01 14:30.
+const dateTime = "date || COALESCE(' ' || time, '')"
+
+// parseDateTime validates a YYYY-MM-DD date with an optional HH:MM time of day, returning both.
+func parseDateTime(s string) (string, string, error) {
+	date, clock, hasClock := strings.Cut(strings.TrimSpace(s), " ")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", "", fmt.Errorf("%w: invalid date %q, expecting YYYY-MM-DD or YYYY-MM-DD HH:MM", errUser, s)
+	}
+	clock = strings.TrimSpace(clock)
+	if _, err := time.Parse("15:04", clock); hasClock && err != nil {
+		return "", "", fmt.Errorf("%w: invalid time %q, expecting HH:MM", errUser, clock)
+	}
+	return date, clock, nil
+}
+
+// editableColumns are the transaction columns that can be set through updateTransaction.
+var editableColumns = []string{"cost", "category", "comment", "date", "time"}
+
+func updateTransaction(db database, id int, fields map[string]any) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: nothing to edit in transaction with id %d, supply a cost, category, -c or -d", errUser, id)
+	}
+
+	var (
+		assignments []string
+		args        []any
+	)
+	for _, column := range editableColumns {
+		value, ok := fields[column]
+		if !ok {
+			continue
+		}
+		assignments = append(assignments, column+" = ?")
+		args = append(args, value)
+	}
+	if len(assignments) != len(fields) {
+		return fmt.Errorf("unknown column in transaction update fields: %v", fields)
+	}
+	args = append(args, id)
+
+	query := "UPDATE %s SET " + strings.Join(assignments, ", ") + " WHERE id = ?" //nolint:gosec // columns come from editableColumns
+	n, err := execTransaction(db, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: no transaction with id %d", errUser, id)
+	}
+
+	if comment, ok := fields["comment"].(string); ok {
+		err = tagTransaction(db, int64(id), comment)
+		if err != nil {
+			return err
+		}
+	}
+
+	t, err := getTransaction(db, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated transaction %v\n", t)
+	return nil
+}
+
+func editFields(a arguments, f flags) map[string]any {
+	fields := map[string]any{}
+	if a.costSet {
+		fields["cost"] = a.cost
+	}
+	if strings.TrimSpace(a.category) != "" {
+		fields["category"] = a.category
+	}
+	if f.comment != "" {
+		fields["comment"] = f.comment
+	}
+	if f.date != "" {
+		date, clock, _ := strings.Cut(f.date, " ")
+		fields["date"] = date
+		if clock != "" {
+			fields["time"] = clock
+		}
+	}
+	return fields
+}
+
+// listTransactions prints the most recent transactions, up to the limit, costing between the given amounts.
+func listTransactions(db database, limit int, minCost, maxCost amountFlag, dateFormat string) error {
+	lower, upper := cents(math.MinInt64), cents(math.MaxInt64) // an open bound
+	if minCost.set {
+		lower = minCost.amount
+	}
+	if maxCost.set {
+		upper = maxCost.amount
+	}
+	rows, err := db.Query(`
+SELECT
+    id, cost, category, COALESCE(comment, ''), `+dateTime+`, COALESCE(currency, ''), receipt IS NOT NULL
+FROM
+    transactions
+WHERE
+    cost BETWEEN ? AND ?
+ORDER BY
+    date DESC, time DESC, id DESC
+LIMIT ?;
+	`, lower, upper, limit)
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	out, err := transactionsTable(rows, dateFormat)
+	if err != nil {
+		return err
+	}
+	if len(out.rows) == 0 && (minCost.set || maxCost.set) {
+		fmt.Println("No transactions in that amount range.")
+		return nil
+	}
+	if len(out.rows) == 0 {
+		fmt.Println("No transactions yet.")
+		return nil
+	}
+	out.print()
+	return nil
+}
+
+// searchTransactions prints the transactions with the text in their category or comment, ignoring the case.
+func searchTransactions(db database, q, dateFormat string) error {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q) + "%"
+	rows, err := db.Query(`
+SELECT
+    id, cost, category, COALESCE(comment, ''), `+dateTime+`, COALESCE(currency, ''), receipt IS NOT NULL
+FROM
+    `+allTransactions+`
+WHERE
+    category LIKE ?1 ESCAPE '\' OR comment LIKE ?1 ESCAPE '\'
+ORDER BY
+    date DESC, time DESC, id DESC;
+	`, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to search transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	out, err := transactionsTable(rows, dateFormat)
+	if err != nil {
+		return err
+	}
+	if len(out.rows) == 0 {
+		fmt.Printf("No matches for %q.\n", q)
+		return nil
+	}
+	out.print()
+	return nil
+}
+
+// transactionsTable builds the table of the transactions rows, selected as id, cost, category, comment, date, currency
+// and whether they have a receipt, with the dates in the format given.
+func transactionsTable(rows *sql.Rows, dateFormat string) (table, error) {
+	out := table{headers: []string{"ID", "Date", "Cost", "Category", "Comment", "Receipt"}}
+	for rows.Next() {
+		var (
+			t          transaction
+			hasReceipt bool
+		)
+		if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date, &t.currency, &hasReceipt); err != nil {
+			return out, fmt.Errorf("failed to scan row: %w", err)
+		}
+		category := "N/A"
+		if t.category.Valid {
+			category = t.category.String
+		}
+		receipt := ""
+		if hasReceipt {
+			receipt = "yes"
+		}
+		out.rows = append(out.rows, []string{
+			strconv.Itoa(t.id), displayDate(t.date, dateFormat), costWithCurrency(t.cost, t.currency), category, t.comment, receipt,
+		})
+	}
+	if rows.Err() != nil {
+		return out, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return out, nil
+}
+
+// csvHeader is the header of the CSV files written by dbExport and read by dbImport.
+var csvHeader = []string{"id", "cost", "category", "comment", "date", "currency"}
+
+// csvColumns are the indexes of the transaction fields in the records of a CSV file, -1 when the file has no such
+// column, and the format of its dates, e.g. DD/MM/YYYY.
+type csvColumns struct {
+	cost, category, comment, date, currency int
+	dateFormat                              string
+	named                                   bool // the indexes are found by name in the header of the file
+}
+
+// exportColumns are the columns of the CSV files written by dbExport, found by the names of csvHeader so that the
+// id can be left out or the columns reordered.
+var exportColumns = csvColumns{cost: 1, category: 2, comment: 3, date: 4, currency: 5, dateFormat: "YYYY-MM-DD", named: true}
+
+// headerColumns finds the columns of the transaction fields by their names in the header, the id and unknown columns
+// are ignored.
+func headerColumns(header []string, dateFormat string) (csvColumns, error) {
+	columns := csvColumns{cost: -1, category: -1, comment: -1, date: -1, currency: -1, dateFormat: dateFormat}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) { // spreadsheets may start with a BOM
+		case "cost":
+			columns.cost = i
+		case "category":
+			columns.category = i
+		case "comment":
+			columns.comment = i
+		case "date":
+			columns.date = i
+		case "currency":
+			columns.currency = i
+		}
+	}
+	if columns.cost < 0 || columns.date < 0 {
+		return columns, fmt.Errorf("%w: missing the cost or date column in the header %q", errUser, strings.Join(header, ","))
+	}
+	return columns, nil
+}
+
+// dateLayout turns a date format as DD/MM/YYYY into the layout of time.Parse.
+func dateLayout(format string) string {
+	return strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02").Replace(format)
+}
+
+// dateRangeClause returns the WHERE clause, and its arguments, to filter transactions between two dates when any is set.
+func dateRangeClause(startDate, endDate string) (string, []any) {
+	if startDate == "" && endDate == "" {
+		return "", nil
+	}
+	if startDate == "" {
+		startDate = "0000-00-00"
+	}
+	if endDate == "" {
+		endDate = "9999-12-31"
+	}
+	return " WHERE date BETWEEN ? AND ?", []any{startDate, endDate}
+}
+
+// exportBufferSize is the size of the writes of the CSV export, so a large export takes few of them.
+const exportBufferSize = 64 << 10
+
+// exportCost writes the cost with the decimals given, e.g. 1500 instead of 1500.00 for JPY, unless that loses cents.
+func exportCost(c cents, decimals int) string {
+	if c%cents(math.Pow10(centsDigits-decimals)) != 0 {
+		return c.String()
+	}
+	sign, units, fraction := c.round(decimals)
+	if fraction == "" {
+		return sign + units
+	}
+	return sign + units + "." + fraction
+}
+
+// dbExport writes the transactions between the dates to a CSV file, failing without leaving a file behind when the
+// context is cancelled, e.g. on Ctrl-C. The costs have the decimals given when no cents are lost to it.
+func dbExport(ctx context.Context, db database, filePath, startDate, endDate string, decimals int) error {
+	where, args := dateRangeClause(startDate, endDate)
+	from, err := transactionsIn(db, cmp.Or(startDate, "0000-00-00"), cmp.Or(endDate, "9999-12-31"))
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(
+		"SELECT id, cost, category, COALESCE(comment, ''), "+dateTime+", COALESCE(currency, '') FROM "+from+where, args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	f, err := os.Create(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to create export file %q: %w", filePath, err)
+	}
+	complete := false
+	defer func() { // after closing it
+		if !complete { // no half written exports
+			handleErrClose(func() error { return os.Remove(filepath.Clean(filePath)) })
+		}
+	}()
+	defer handleErrClose(f.Close)
+
+	buffered := bufio.NewWriterSize(f, exportBufferSize)
+	w := csv.NewWriter(buffered)
+	if err := w.Write(csvHeader); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+
+	for rows.Next() {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: export cancelled, no file written to %q", errUser, filePath)
+		}
+		var t transaction
+		if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date, &t.currency); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		record := []string{strconv.Itoa(t.id), exportCost(t.cost, decimals), t.category.String, t.comment, t.date, t.currency}
+		if err := w.Write(record); err != nil {
+			return fmt.Errorf("failed to write to export file: %w", err)
+		}
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	complete = true
+
+	return nil
+}
+
+// jsonTransaction is the JSON representation of a transaction used by dbExportJSON.
+type jsonTransaction struct {
+	ID       int         `json:"id"`
+	Cost     json.Number `json:"cost"`
+	Category *string     `json:"category"`
+	Comment  string      `json:"comment"`
+	Date     string      `json:"date"`
+	Currency string      `json:"currency,omitempty"` // of a transaction not in the base currency
+}
+
+func dbExportJSON(db database, filePath, startDate, endDate string) error {
+	where, args := dateRangeClause(startDate, endDate)
+	from, err := transactionsIn(db, cmp.Or(startDate, "0000-00-00"), cmp.Or(endDate, "9999-12-31"))
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(
+		"SELECT id, cost, category, COALESCE(comment, ''), "+dateTime+", COALESCE(currency, '') FROM "+from+where, args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	transactions := []jsonTransaction{}
+	for rows.Next() {
+		var t transaction
+		if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date, &t.currency); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		jt := jsonTransaction{ID: t.id, Cost: json.Number(t.cost.String()), Comment: t.comment, Date: t.date, Currency: t.currency}
+		if t.category.Valid {
+			jt.Category = &t.category.String
+		}
+		transactions = append(transactions, jt)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	f, err := os.Create(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to create export file %q: %w", filePath, err)
+	}
+	defer handleErrClose(f.Close)
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(transactions); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	return nil
+}
+
+// dbExportLedger writes the transactions as ledger journal entries, readable by ledger, hledger or beancount's
+// converters, each one moving the cost from the Assets:Cash account to the Expenses account of its category, or to it
+// from the Income account for income.
+func dbExportLedger(db database, filePath, startDate, endDate, currency string) error {
+	where, args := dateRangeClause(startDate, endDate)
+	from, err := transactionsIn(db, cmp.Or(startDate, "0000-00-00"), cmp.Or(endDate, "9999-12-31"))
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(
+		"SELECT cost, category, COALESCE(comment, ''), date, COALESCE(currency, '') FROM "+from+where+" ORDER BY date, time, id", args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	f, err := os.Create(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to create export file %q: %w", filePath, err)
+	}
+	defer handleErrClose(f.Close)
+
+	w := bufio.NewWriter(f)
+	for rows.Next() {
+		var t transaction
+		if err := rows.Scan(&t.cost, &t.category, &t.comment, &t.date, &t.currency); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		category := "Unknown"
+		if t.category.Valid {
+			category = t.category.String
+		}
+		account := "Expenses:" + category
+		if t.cost < 0 {
+			account = "Income:" + category
+		}
+		amount := currency + t.cost.String()
+		if t.cost < 0 {
+			amount = "-" + currency + (-t.cost).String()
+		}
+		if t.currency != "" { // a commodity after the amount, e.g. 25.00 USD
+			amount = t.cost.String() + " " + t.currency
+		}
+		fmt.Fprintf(w, "%s %s\n", t.date, category)
+		if t.comment != "" {
+			fmt.Fprintf(w, "    ; %s\n", t.comment)
+		}
+		fmt.Fprintf(w, "    %s  %s\n    Assets:Cash\n\n", account, amount)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write to export file: %w", err)
+	}
+	return nil
+}
+
+// dbImportJSON replaces all the current transactions with the ones in a file written by dbExportJSON,
+// either all of them are imported or nothing changes.
+func dbImportJSON(ctx context.Context, db *sql.DB, filePath string, o importOptions) error {
+	b, err := os.ReadFile(filepath.Clean(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to read import file %q: %w", filePath, err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("%w: import file %s is not a JSON array of transactions: %w", errUser, filePath, err)
+	}
+
+	return importTransactions(ctx, db, filePath, o, func(dups *duplicates) ([]transaction, error) {
+		var (
+			transactions []transaction
+			invalid      []error
+		)
+		for i, r := range raw {
+			var jt jsonTransaction
+			if err := json.Unmarshal(r, &jt); err != nil {
+				invalid = append(invalid, fmt.Errorf("%w: invalid transaction in import file %s, index %d: %w", errUser, filePath, i, err))
+				continue
+			}
+			cost, err := parseCents(jt.Cost.String())
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("%w: invalid cost value in import file %s, index %d: %q", errUser, filePath, i, jt.Cost))
+				continue
+			}
+			if _, _, err := parseDateTime(jt.Date); err != nil {
+				invalid = append(invalid, fmt.Errorf("%w in import file %s, index %d", err, filePath, i))
+				continue
+			}
+			var currency string
+			if jt.Currency != "" {
+				currency, err = parseCurrencyCode(jt.Currency)
+				if err != nil {
+					invalid = append(invalid, fmt.Errorf("%w in import file %s, index %d", err, filePath, i))
+					continue
+				}
+			}
+			var category string
+			if jt.Category != nil {
+				category = *jt.Category
+			}
+			if dups.skip(cost, category, jt.Comment, jt.Date) {
+				continue
+			}
+			t := newTransaction(cost, category, jt.Comment, jt.Date)
+			t.currency = currency
+			transactions = append(transactions, t)
+		}
+		return transactions, errors.Join(invalid...)
+	})
+}
+
+// dbBatch adds all the transactions of a file, or of the standard input if the path is "-",
+// either all of them are added or nothing changes.
+func dbBatch(db *sql.DB, filePath, date string) error {
+	var r io.Reader = os.Stdin
+	if filePath != "-" {
+		f, err := os.Open(filepath.Clean(filePath))
+		if err != nil {
+			return fmt.Errorf("failed to open batch file %q: %w", filePath, err)
+		}
+		defer handleErrClose(f.Close)
+		r = f
 	}
-	defer handleErrClose(rows.Close)
 
-	f, err := os.Create(filepath.Clean(filePath))
+	var transactions []transaction
+	err := withTx(db, func(tx database) error {
+		scanner := bufio.NewScanner(r)
+		lineNum := 0
+		for scanner.Scan() {
+			lineNum++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue // skip empty lines and comments
+			}
+
+			// <cost> [<category>] [<comment>], where the comment is the rest of the line
+			fields := strings.Fields(line)
+			cost, err := parseAmount(fields[0])
+			if err != nil {
+				return fmt.Errorf("%w: invalid cost value in batch file %s, line %d: %s", errUser, filePath, lineNum, fields[0])
+			}
+			var category, comment string
+			if len(fields) > 1 {
+				category = fields[1]
+				comment = strings.Join(fields[2:], " ")
+			}
+
+			transactions = append(transactions, newTransaction(cost, category, comment, date))
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading batch file: %w", err)
+		}
+		if err := insertTransactions(context.Background(), tx, transactions); err != nil {
+			return fmt.Errorf("failed to insert transactions from batch file: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create export file %q: %w", filePath, err)
+		return err
 	}
-	defer handleErrClose(f.Close)
+	fmt.Printf("Added %d transactions.\n", len(transactions))
+	return nil
+}
 
-	if _, err := f.WriteString("id,cost,category,comment,date\n"); err != nil {
-		return fmt.Errorf("failed to write to export file: %w", err)
+// withTx runs f inside a single database transaction, rolling back all of its changes if f fails.
+func withTx(db *sql.DB, f func(tx database) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := f(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			slog.Error("Failed to rollback transaction", "error", rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// dbImport replaces all the current transactions with the ones in the file, or adds them for a bank file, either all
+// of them are imported or nothing changes.
+func dbImport(ctx context.Context, db *sql.DB, filePath string, o importOptions) error {
+	return importTransactions(ctx, db, filePath, o, func(dups *duplicates) ([]transaction, error) {
+		return readCSV(filePath, o.columns, dups)
+	})
+}
+
+// importOptions are the modifiers of an import.
+type importOptions struct {
+	confirm func(string) bool // asked before replacing the current transactions
+	dryRun  bool
+	add     bool       // add to the current transactions instead of replacing them
+	dedup   bool       // skip the transactions equal to a current one
+	columns csvColumns // of a CSV file
+}
+
+// transactionKey are the fields that make two transactions duplicates.
+type transactionKey struct {
+	cost                    cents
+	category, comment, date string
+}
+
+// duplicates finds the imported transactions equal to a transaction already in the database.
+type duplicates struct {
+	existing map[transactionKey]bool
+	skipped  int
+}
+
+func newDuplicates(db database) (*duplicates, error) {
+	rows, err := db.Query("SELECT cost, COALESCE(category, ''), COALESCE(comment, ''), date, " + dateTime + " FROM " + allTransactions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
 	}
+	defer handleErrClose(rows.Close)
 
+	d := &duplicates{existing: map[transactionKey]bool{}}
 	for rows.Next() {
-		var id int
-		var cost float64
-		var category, comment, date string
-		if err := rows.Scan(&id, &cost, &category, &comment, &date); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
-		}
-		line := fmt.Sprintf("%d,%.2f,%s,%s,%s\n", id, cost, category, comment, date)
-		if _, err := f.WriteString(line); err != nil {
-			return fmt.Errorf("failed to write to export file: %w", err)
+		var (
+			k    transactionKey
+			when string
+		)
+		if err := rows.Scan(&k.cost, &k.category, &k.comment, &k.date, &when); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %w", err)
 		}
+		d.existing[k] = true // for the imported transactions without a time of day, e.g. most bank files
+		k.date = when
+		d.existing[k] = true
 	}
 	if rows.Err() != nil {
-		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return d, nil
+}
+
+// skip reports whether the transaction is a duplicate, counting it, a nil duplicates skips nothing. A date without a
+// time of day matches the transactions of that day at any time.
+func (d *duplicates) skip(cost cents, category, comment, date string) bool {
+	if d == nil || !d.existing[transactionKey{cost, strings.TrimSpace(category), comment, date}] {
+		return false
+	}
+	d.skipped++
+	return true
+}
+
+// errors returned inside a transaction to roll it back.
+var (
+	errInterrupted = errors.New("interrupted")
+	errDryRun      = errors.New("dry run")
+)
+
+// importTransactions deletes the current transactions, unless adding to them, and inserts the ones read from the file
+// in a single transaction, asking for confirmation with the number of transactions deleted and imported before
+// replacing them. The file is read once, with the duplicates to skip when deduplicating. A dry run reports what would be
+// imported and rolls back instead.
+func importTransactions(
+	ctx context.Context, db *sql.DB, filePath string, o importOptions, readFile func(dups *duplicates) ([]transaction, error),
+) error {
+	var (
+		transactions []transaction
+		importErr    error
+		read         bool
+	)
+	if !o.add && !o.dryRun {
+		transactions, importErr = readFile(nil)
+		if importErr != nil {
+			return importErr
+		}
+		read = true
+		confirmed, err := confirmReplace(db, filePath, len(transactions), o.confirm)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Println("Operation cancelled.")
+			return nil
+		}
 	}
 
+	var (
+		imported int
+		dups     *duplicates
+	)
+	target := db
+	if o.dryRun { // into a scratch database, so a read only one can be checked too
+		scratch, err := scratchDatabase()
+		if err != nil {
+			return err
+		}
+		defer handleErrClose(scratch.Close)
+		target = scratch
+	}
+	err := withTx(target, func(tx database) error {
+		if o.dedup {
+			var current database = tx
+			if o.dryRun {
+				current = db // the scratch database has none
+			}
+			var err error
+			dups, err = newDuplicates(current)
+			if err != nil {
+				return err
+			}
+		}
+		if !o.add {
+			for _, table := range transactionTables { // the exports have both
+				_, err := tx.Exec("DELETE FROM " + table)
+				if err != nil {
+					return fmt.Errorf("failed to delete current %s: %w", strings.ReplaceAll(table, "_", " "), err)
+				}
+			}
+		}
+		if !read {
+			transactions, importErr = readFile(dups)
+		}
+		err := insertTransactions(ctx, tx, transactions)
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+		if err != nil {
+			return fmt.Errorf("failed to insert transactions from import file: %w", err)
+		}
+		imported = len(transactions)
+		if o.dryRun {
+			return errDryRun
+		}
+		return importErr
+	})
+	if errors.Is(err, errInterrupted) {
+		fmt.Println("Import cancelled, no changes made.")
+		return nil
+	}
+	if dups != nil {
+		fmt.Printf("Skipped %d duplicate transactions.\n", dups.skipped)
+	}
+	if errors.Is(err, errDryRun) {
+		var problems []error
+		if joined, ok := importErr.(interface{ Unwrap() []error }); ok { //nolint:errorlint // the joined errors themselves
+			problems = joined.Unwrap()
+		} else if importErr != nil {
+			problems = []error{importErr}
+		}
+		fmt.Printf("dry run: would import %d transactions, %d errors\n", imported, len(problems))
+		for _, problem := range problems {
+			fmt.Printf("  %v\n", problem)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d transactions.\n", imported)
 	return nil
 }
 
-func dbImport(db database, filePath string) error {
+// scratchDatabase opens an empty database in memory, e.g. to try an import out.
+func scratchDatabase() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scratch database: %w", err)
+	}
+	db.SetMaxOpenConns(1) // every connection has a memory database of its own
+	if err := dbInit(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// confirmReplace asks before an import replaces the current transactions with the incoming ones of the file, when there
+// are any. It is asked before the import transaction begins, so the database is not locked while waiting for the answer.
+func confirmReplace(db *sql.DB, filePath string, incoming int, confirm func(string) bool) (bool, error) {
+	var current int
+	err := db.QueryRow("SELECT (SELECT COUNT(*) FROM transactions) + (SELECT COUNT(*) FROM archived_transactions)").Scan(&current)
+	if err != nil {
+		return false, fmt.Errorf("failed to count current transactions: %w", err)
+	}
+	if current == 0 {
+		return true, nil
+	}
+	return confirm(fmt.Sprintf(
+		"This replaces the %d current transactions with the %d in %q.\nType 'yes' to confirm: ", current, incoming, filePath,
+	)), nil
+}
+
+// readCSV reads the transactions of the file, with its fields in the given columns, but the duplicates. Invalid lines
+// do not stop the reading, all of them are reported together.
+func readCSV(filePath string, columns csvColumns, dups *duplicates) ([]transaction, error) {
 	f, err := os.Open(filepath.Clean(filePath))
 	if err != nil {
-		return fmt.Errorf("failed to open import file %q: %w", filePath, err)
+		return nil, fmt.Errorf("failed to open import file %q: %w", filePath, err)
 	}
 	defer handleErrClose(f.Close)
 
-	scanner := bufio.NewScanner(f)
-	var header bool
-	lineNum := 0
-	for scanner.Scan() {
-		line := scanner.Text()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // we report the invalid lines ourselves
+	fields := max(columns.cost, columns.category, columns.comment, columns.date, columns.currency) + 1
+	layout := dateLayout(columns.dateFormat)
+	column := func(record []string, index int) string {
+		if index < 0 {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+	var (
+		header       bool
+		lineNum      int
+		transactions []transaction
+		invalid      []error
+	)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read import file %s: %w", errUser, filePath, err)
+		}
 		if !header {
 			header = true
+			if columns.named {
+				if columns, err = headerColumns(record, columns.dateFormat); err != nil {
+					return nil, fmt.Errorf("%w of import file %s, map the columns with -imap", err, filePath)
+				}
+				fields = max(columns.cost, columns.category, columns.comment, columns.date, columns.currency) + 1
+			}
 			continue
 		}
 		lineNum++
-		parts := strings.Split(line, ",")
-		if len(parts) < 5 { //nolint:mnd // until someone properly implements the CSV import, I'll just leave this hardcoded
-			return fmt.Errorf("%w: invalid line import file %s, line %d: %s", errUser, filePath, lineNum, line)
+		if len(record) < fields {
+			invalid = append(invalid, fmt.Errorf(
+				"%w: invalid line import file %s, line %d: %s", errUser, filePath, lineNum, strings.Join(record, ","),
+			))
+			continue
 		}
-		cost, err := strconv.ParseFloat(parts[1], 64)
+		cost, err := parseCents(column(record, columns.cost))
 		if err != nil {
-			return fmt.Errorf("%w: invalid cost value in import file %s, line %d: %s", errUser, filePath, lineNum, parts[1])
+			invalid = append(invalid, fmt.Errorf(
+				"%w: invalid cost value in import file %s, line %d: %s", errUser, filePath, lineNum, record[columns.cost],
+			))
+			continue
+		}
+		day, clock, _ := strings.Cut(column(record, columns.date), " ")
+		if strings.Contains(layout, " ") { // the layout has the time of day, e.g. DD/MM/YYYY HH:MM
+			day, clock = column(record, columns.date), ""
+		}
+		date, err := time.Parse(layout, day)
+		if err == nil && clock != "" {
+			_, err = time.Parse("15:04", clock)
 		}
-		category := parts[2]
-		comment := parts[3]
-		date := parts[4]
-
-		err = insertTransaction(db, cost, category, comment, date)
 		if err != nil {
-			return fmt.Errorf("failed to insert transaction from import file: %w", err)
+			invalid = append(invalid, fmt.Errorf(
+				"%w: invalid date in import file %s, line %d: %q, expecting %s", errUser, filePath, lineNum, record[columns.date], columns.dateFormat,
+			))
+			continue
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading import file: %w", err)
-	}
+		currency := column(record, columns.currency)
+		if currency != "" {
+			currency, err = parseCurrencyCode(currency)
+			if err != nil {
+				invalid = append(invalid, fmt.Errorf("%w in import file %s, line %d", err, filePath, lineNum))
+				continue
+			}
+		}
 
-	return nil
+		category, comment := column(record, columns.category), column(record, columns.comment)
+		when := strings.TrimSpace(date.Format("2006-01-02") + " " + clock)
+		if date.Hour() != 0 || date.Minute() != 0 {
+			when = date.Format("2006-01-02 15:04")
+		}
+		if dups.skip(cost, category, comment, when) {
+			continue
+		}
+		t := newTransaction(cost, category, comment, when)
+		t.currency = currency
+		transactions = append(transactions, t)
+	}
+	return transactions, errors.Join(invalid...)
 }
 
-func confirmYeet(confirmationQuestion string) bool {
-	fmt.Print(confirmationQuestion)
-	var confirmation string
-	_, err := fmt.Scanln(&confirmation)
-	if err != nil {
-		fmt.Printf("Failed to read confirmation input: %v\n", err)
-		return false
+// askConfirmation returns a confirm asking the question on the terminal, confirming only when the answer is yes. Once
+// ctx is done, e.g. on Ctrl-C, it stops waiting for the answer and does not confirm.
+func askConfirmation(ctx context.Context) func(string) bool {
+	return func(confirmationQuestion string) bool {
+		fmt.Print(confirmationQuestion)
+		answer := make(chan string, 1)
+		go func() {
+			var confirmation string
+			_, err := fmt.Scanln(&confirmation)
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("Failed to read confirmation input: %v\n", err)
+			}
+			answer <- confirmation
+		}()
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return false
+		case confirmation := <-answer:
+			return confirmation == "yes"
+		}
 	}
-	return confirmation == "yes"
 }
 
-func yeet(databasePath string) error {
+// yeetTargets are the resources that can be wiped by -yeet, in the order they are wiped.
+var yeetTargets = []string{"db", "config", "logs"}
+
+func yeet(ctx context.Context, databasePath string, targets []string, force bool) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("failed to get home directory: %w", err)
 	}
-
-	ok := confirmYeet(fmt.Sprintf("Are you sure you want to wipe the database at %q?\nType 'yes' to confirm: ", databasePath))
-	if !ok {
-		fmt.Println("Operation cancelled.")
-		return nil
-	}
-	err = os.Remove(databasePath)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to remove database file %q: %w", databasePath, err)
+	confirm := askConfirmation(ctx)
+	if force {
+		slog.Warn("Forced wipe of user data, skipping confirmations", "targets", targets, "database", databasePath)
+		confirm = func(string) bool { return true }
 	}
-	fmt.Println("Database wiped successfully.")
 
 	configPath := os.Getenv(configFileEnv)
 	if configPath == "" {
-		configPath = filepath.Join(homeDir, defaultConfigFile)
-	}
-	ok = confirmYeet(fmt.Sprintf("Are you sure you want to wipe the config file at %q?\nType 'yes' to confirm: ", configPath))
-	if !ok {
-		fmt.Println("Operation cancelled.")
-		return nil
-	}
-	err = os.Remove(configPath)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to remove config file %q: %w", configPath, err)
+		configPath = userPath(homeDir, defaultConfigFile)
 	}
-	fmt.Println("Config file wiped successfully.")
-
 	logFile := os.Getenv(logFileEnv)
 	if logFile == "" {
-		logFile = filepath.Join(homeDir, defaultLogFile)
+		logFile = userPath(homeDir, defaultLogFile)
 	}
-	ok = confirmYeet(fmt.Sprintf("Are you sure you want to wipe the log file at %q?\nType 'yes' to confirm: ", logFile))
-	if !ok {
-		fmt.Println("Operation cancelled.")
-		return nil
+	resources := map[string]struct{ name, path string }{
+		"db":     {"database", databasePath},
+		"config": {"config file", configPath},
+		"logs":   {"log file", logFile},
 	}
-	err = os.Remove(logFile)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("failed to remove log file %q: %w", logFile, err)
+
+	for _, target := range yeetTargets {
+		if !slices.Contains(targets, target) && !slices.Contains(targets, "all") {
+			continue
+		}
+		r := resources[target]
+		ok := confirm(fmt.Sprintf("Are you sure you want to wipe the %s at %q?\nType 'yes' to confirm: ", r.name, r.path))
+		if !ok {
+			fmt.Println("Operation cancelled.")
+			return nil
+		}
+		err = os.Remove(r.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s %q: %w", r.name, r.path, err)
+		}
+		if target == "db" { // leftovers of the WAL journal mode
+			for _, suffix := range []string{"-wal", "-shm"} {
+				err = os.Remove(r.path + suffix)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to remove %s %q: %w", r.name, r.path+suffix, err)
+				}
+			}
+		}
+		fmt.Printf("%s%s wiped successfully.\n", strings.ToUpper(r.name[:1]), r.name[1:])
 	}
-	fmt.Println("Log file wiped successfully.")
 	fmt.Println("All specified user data has been wiped successfully.")
 	return nil
 }
@@ -423,37 +3028,371 @@ func main() {
 	defer func() { _ = cleanup() }()
 	feedbackOnErr(err)
 
-	a, f := parse()
-	c, err := loadUserConfig()
-	feedbackOnErr(err)
+	// a signal cancels ctx instead of killing the process, so the prompts, imports and exports stop cleanly and the
+	// deferred cleanup, e.g. the reseal of an encrypted database, runs once they have unwound
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a, f := parse(os.Args[1:])
+	c, configErr := loadUserConfig()
+	if !f.check { // the check reports the config problems itself
+		feedbackOnErr(configErr)
+	}
+	if f.ledger != "" {
+		f.database, err = ledgerPath(f.ledger)
+		feedbackOnErr(err)
+	}
+	c.databasePath = resolveDatabasePath(c.databasePath, f.database)
 
-	if f.yeet {
+	if what := mutation(a, f); f.readonly && what != "" {
+		feedbackOnErr(fmt.Errorf("%w: %s is not allowed with -readonly", errUser, what))
+	}
+
+	if f.yeet.set {
 		err = cleanup() // if we're yeeting the log file, we have to close it
 		feedbackOnErr(err)
-		err = yeet(c.databasePath)
+		err = yeet(ctx, c.databasePath, f.yeet.targets, f.force)
+		feedbackOnErr(err)
+		return
+	}
+
+	if f.ledgers {
+		err = listLedgers()
+		feedbackOnErr(err)
+		return
+	}
+
+	if c.encrypted {
+		c.passphrase, err = readPassphrase(ctx)
+		feedbackOnErr(err)
+	}
+
+	if f.check {
+		err = checkSetup(c, configErr)
 		feedbackOnErr(err)
 		return
 	}
 
-	db, err := sql.Open("sqlite", c.databasePath)
+	if !f.readonly {
+		err = os.MkdirAll(filepath.Dir(c.databasePath), 0o700) //nolint:mnd // reasonable dir permissions
+		feedbackOnErr(err)
+	}
+	databasePath := c.databasePath
+	if c.encrypted { // work on a decrypted copy, encrypted back when done
+		var seal func() error
+		databasePath, seal, err = decryptDatabase(c.databasePath, c.passphrase, f.readonly)
+		feedbackOnErr(err)
+		defer reseal(seal)
+	}
+	db, err := sql.Open("sqlite", databaseDSN(databasePath, f.readonly))
+	feedbackOnErr(err)
+	defer handleErrClose(db.Close) // before encrypting it
+	if f.readonly {
+		err = checkSchema(db, databasePath)
+	} else {
+		err = dbInit(db)
+	}
 	feedbackOnErr(err)
-	err = dbInit(db)
+
+	err = run(ctx, db, a, f, c)
 	feedbackOnErr(err)
+}
+
+// databaseDSN returns the data source name to open the database, in WAL mode so reads don't block on writes, or read
+// only. It is a file URI, so a path with a ? or a # in it is not taken for its query.
+func databaseDSN(databasePath string, readonly bool) string {
+	path := filepath.ToSlash(databasePath)
+	if filepath.VolumeName(databasePath) != "" { // e.g. file:///C:/Users
+		path = "/" + path
+	}
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: "_pragma=journal_mode(WAL)"}
+	if readonly {
+		dsn.RawQuery = "mode=ro"
+	}
+	return dsn.String()
+}
+
+// checkSchema fails when the database is missing or older than this version, which dbInit creates or upgrades but
+// can't while read only.
+func checkSchema(db database, databasePath string) error {
+	if _, err := os.Stat(databasePath); err != nil {
+		return fmt.Errorf("%w: no database at %q to open read only", errUser, databasePath)
+	}
+	version, err := userVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < schemaVersion {
+		return fmt.Errorf("%w: the database %q is out of date, run liet once without -readonly to upgrade it", errUser, databasePath)
+	}
+	return nil
+}
 
+// mutation returns what the arguments and flags would change in the database or the user data, or an empty string
+// when they only read it.
+func mutation(a arguments, f flags) string {
 	switch {
-	case a.cost != 0:
-		err = insertTransaction(db, a.cost, a.category, f.comment, f.date)
-		feedbackOnErr(err)
-	case f.stats != "":
-		err = statsRunner(db, f.stats)
-		feedbackOnErr(err)
+	case f.yeet.set:
+		return "removing the user data"
+	case f.edit != 0:
+		return "editing a transaction"
+	case a.costSet || f.repl || f.batch != "":
+		return "adding transactions"
+	case f.recur == "add" || f.recur == "apply":
+		return "adding recurring transactions"
+	case f.remove != 0 || f.undo:
+		return "removing transactions"
+	case f.rename.set || f.merge.set:
+		return "changing categories"
+	case f.compact:
+		return "compacting the database"
+	case f.archive != "":
+		return "archiving transactions"
+	case (f.importCSV != "" || f.importJSON != "") && !f.dryRun:
+		return "importing transactions"
+	default:
+		return ""
+	}
+}
+
+// recordTransaction inserts the transaction of the arguments, or one per category of a split, and warns when they go
+// over budget, returning the cost stored, i.e. negative for income.
+func recordTransaction(db database, a arguments, f flags, c userConfig) (cents, error) {
+	parts := []splitPart{{cost: a.cost, category: a.category}}
+	if f.split.set {
+		var err error
+		parts, err = f.split.of(a.cost)
+		if err != nil {
+			return 0, err
+		}
+	}
+	receipt := ""
+	if f.receipt != "" {
+		var err error
+		receipt, err = receiptPath(f.receipt)
+		if err != nil {
+			return 0, err
+		}
+	}
+	currency := ""
+	if f.currency != "" {
+		var err error
+		currency, err = parseCurrencyCode(f.currency)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if currency == c.baseCurrency {
+		currency = "" // stored as the base currency, whatever it is
+	}
+	date, clock, _ := strings.Cut(f.date, " ")
+	if clock == "" && date == time.Now().Format("2006-01-02") { // the time of day is only known for today's entries
+		clock = time.Now().Format("15:04")
+	}
+
+	var total cents
+	for _, p := range parts {
+		cost := p.cost
+		if f.income { // income is stored as a negative cost
+			cost = -cost
+		}
+		category := p.category
+		if strings.TrimSpace(category) == "" {
+			category = c.defaultCategory
+		}
+		t := newTransaction(cost, category, f.comment, strings.TrimSpace(date+" "+clock))
+		t.receipt, t.currency = receipt, currency
+		id, err := t.insert(db)
+		if err != nil {
+			return 0, err
+		}
+		o := statsOptions{currency: c.currency, numbers: c.numbers}
+		if currency != "" {
+			fmt.Printf("Added transaction #%d: %s %s\n", id, costWithCurrency(cost, currency), categoryOrNA(strings.TrimSpace(category)))
+			continue // neither budgeted nor part of the total of the base currency
+		}
+		fmt.Printf("Added transaction #%d: %s %s\n", id, o.formatCost(cost), categoryOrNA(strings.TrimSpace(category)))
+		if err := budgetWarning(db, c.budgets, category, date); err != nil {
+			return 0, err
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+// confirmCategory asks whether a new category is a typo of an existing one, e.g. grocerys of groceries, returning the
+// category to record.
+func confirmCategory(db database, category string, confirm func(string) bool) (string, error) {
+	category = strings.TrimSpace(category)
+	if category == "" {
+		return category, nil
+	}
+	rows, err := db.Query("SELECT DISTINCT category FROM " + allTransactions + " WHERE category IS NOT NULL")
+	if err != nil {
+		return "", fmt.Errorf("failed to query categories: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	closest, closestDistance := "", maxTypoDistance+1
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", fmt.Errorf("failed to scan category: %w", err)
+		}
+		if existing == category {
+			return category, nil
+		}
+		distance := levenshtein(strings.ToLower(existing), strings.ToLower(category))
+		if distance < closestDistance || distance == closestDistance && existing < closest {
+			closest, closestDistance = existing, distance
+		}
+	}
+	if rows.Err() != nil {
+		return "", fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	if closest != "" && confirm(fmt.Sprintf("There is no category %q, did you mean %q?\nType 'yes' to use it: ", category, closest)) {
+		return closest, nil
+	}
+	return category, nil
+}
+
+// maxTypoDistance is the edit distance up to which a new category is taken as a possible typo of an existing one.
+const maxTypoDistance = 2
+
+// levenshtein returns the number of single character insertions, deletions or substitutions turning a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := range ra {
+		current[0] = i + 1
+		for j := range rb {
+			substitution := previous[j]
+			if ra[i] != rb[j] {
+				substitution++
+			}
+			current[j+1] = min(previous[j+1]+1, current[j]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// importConfirmation asks before an import replaces the current transactions, unless forced.
+func importConfirmation(ctx context.Context, force bool) func(string) bool {
+	if force {
+		return func(string) bool { return true }
+	}
+	return askConfirmation(ctx)
+}
+
+// run executes the command asked for by the arguments and flags, the prompts, imports, exports and repl stop once ctx
+// is done, e.g. on Ctrl-C.
+func run(ctx context.Context, db *sql.DB, a arguments, f flags, c userConfig) error {
+	if (a.costSet || f.batch != "" || f.edit != 0) && !f.force {
+		if err := checkFutureDate(f.date, time.Now()); err != nil {
+			return fmt.Errorf("%w, add -force to record it anyway", err)
+		}
+	}
+	switch {
+	case f.edit != 0:
+		return updateTransaction(db, f.edit, editFields(a, f))
+	case a.costSet:
+		if !f.force {
+			var err error
+			a.category, err = confirmCategory(db, a.category, askConfirmation(ctx))
+			if err != nil {
+				return err
+			}
+			for i, p := range f.split.parts {
+				f.split.parts[i].category, err = confirmCategory(db, p.category, askConfirmation(ctx))
+				if err != nil {
+					return err
+				}
+			}
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: interrupted, nothing recorded", errUser)
+			}
+		}
+		return withTx(db, func(tx database) error { // all the parts of a split or none
+			_, err := recordTransaction(tx, a, f, c)
+			return err
+		})
+	case f.repl:
+		return repl(ctx, db, c, os.Stdin, f.force)
+	case f.batch != "":
+		return dbBatch(db, f.batch, f.date)
+	case f.list.set:
+		return listTransactions(db, f.list.limit, f.minCost, f.maxCost, c.dateFormat)
+	case f.recur != "":
+		return recur(db, f.recur, f.recurSpec, f.comment, f.date)
+	case f.find != "":
+		return searchTransactions(db, f.find, c.dateFormat)
+	case f.open != 0:
+		return openReceipt(db, f.open)
+	case f.remove != 0:
+		return deleteTransaction(db, f.remove)
+	case f.undo:
+		return undoLast(db)
+	case f.categories:
+		return listCategories(db)
+	case f.rename.set:
+		return renameCategory(db, f.rename.from, f.rename.to)
+	case f.merge.set:
+		return mergeCategories(db, f.merge.sources, f.merge.target)
+	case f.backup != "":
+		return backupDatabase(db, f.backup, c.passphrase)
+	case f.compact:
+		return compactDatabase(db)
+	case f.archive != "":
+		return archiveTransactions(db, resolveDate(f.archive, time.Now()))
+	case f.stats.set:
+		renderer, err := newRenderer(f.format)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(sortOrders, f.sort) {
+			return fmt.Errorf("%w: unknown sort order %q, expecting one of %s", errUser, f.sort, strings.Join(sortOrders, ", "))
+		}
+		if f.rate < 0 || math.IsInf(f.rate, 0) || math.IsNaN(f.rate) {
+			return fmt.Errorf("%w: invalid rate %v, expecting a positive rate, e.g. 1.08", errUser, f.rate)
+		}
+		o := statsOptions{
+			weekStart: c.weekStart, budgets: c.budgets, currency: c.currency, numbers: c.numbers, renderer: renderer, sort: f.sort,
+			minShare: float64(f.minShare), counts: f.counts, reportCurrency: c.reportCurrency, reportRate: cmp.Or(f.rate, c.reportRate),
+			dateFormat: c.dateFormat,
+		}
+		if _, ok := renderer.(csvRenderer); ok {
+			o.currency, o.reportCurrency, o.numbers = "", "", numberFormat{} // spreadsheets expect plain numbers
+		}
+		return statsRunner(db, cmp.Or(f.stats.window, c.defaultStats), o)
 	case f.exportCSV != "":
-		err = dbExport(db, f.exportCSV)
-		feedbackOnErr(err)
+		return dbExport(ctx, db, f.exportCSV, f.date, f.dateEnd, c.decimals)
+	case f.exportJSON != "":
+		return dbExportJSON(db, f.exportJSON, f.date, f.dateEnd)
+	case f.exportLedger != "":
+		return dbExportLedger(db, f.exportLedger, f.date, f.dateEnd, c.currency)
+	case f.exportHTML != "":
+		o := statsOptions{currency: c.currency, numbers: c.numbers, sort: f.sort, dateFormat: c.dateFormat}
+		return dbExportHTML(db, f.exportHTML, f.date, f.dateEnd, o)
+	case f.importJSON != "":
+		return dbImportJSON(ctx, db, f.importJSON, importOptions{
+			confirm: importConfirmation(ctx, f.force), dryRun: f.dryRun, add: f.dedup, dedup: f.dedup,
+		})
 	case f.importCSV != "":
-		err = dbImport(db, f.importCSV)
-		feedbackOnErr(err)
+		columns := exportColumns
+		if f.importMap.set {
+			columns = f.importMap.columns
+		}
+		columns.dateFormat = f.importDate
+		return dbImport(ctx, db, f.importCSV, importOptions{
+			confirm: importConfirmation(ctx, f.force), dryRun: f.dryRun, add: f.importMap.set || f.dedup, dedup: f.dedup, columns: columns,
+		})
 	default:
 		fmt.Println("I don't think you wanted to end up here... How about running with -h for help?")
+		return nil
 	}
 }
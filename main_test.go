@@ -1,9 +1,1466 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
 
 func Test_noop(t *testing.T) {
 	t.Run("noop", func(t *testing.T) {
 		// placeholder.
 	})
 }
+
+// confirmed answers yes to every confirmation.
+func confirmed(string) bool { return true }
+
+func newTestDB(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "liet.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := dbInit(db); err != nil {
+		t.Fatalf("failed to initialize database: %v", err)
+	}
+	return db
+}
+
+func Test_csvRoundTrip(t *testing.T) {
+	src := newTestDB(t)
+	if _, err := insertTransaction(src, 4250, "restaurants", "lunch, drinks, and tip", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(src, 300, "", `a "quoted" comment`, "2023-10-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.csv"), filepath.Join(dir, "second.csv")
+	if err := dbExport(context.Background(), src, first, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestDB(t)
+	if err := dbImport(context.Background(), dst, first, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbExport(context.Background(), dst, second, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(first)
+	got, _ := os.ReadFile(second)
+	if string(got) != string(want) {
+		t.Errorf("round trip mismatch:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func Test_csvRoundTripByHeader(t *testing.T) {
+	src := newTestDB(t)
+	for _, date := range []string{"2023-09-30", "2023-10-01", "2023-10-02"} {
+		if _, err := insertTransaction(src, 4250, "restaurants", "lunch, drinks, and tip", date); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := src.Exec("DELETE FROM transactions WHERE id = 1"); err != nil { // the ids no longer start at 1
+		t.Fatal(err)
+	}
+	withoutIDs := func(path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for line := range strings.Lines(string(b)) {
+			_, rest, _ := strings.Cut(line, ",")
+			lines = append(lines, rest)
+		}
+		return strings.Join(lines, "")
+	}
+
+	dir := t.TempDir()
+	first, second, third := filepath.Join(dir, "first.csv"), filepath.Join(dir, "second.csv"), filepath.Join(dir, "third.csv")
+	if err := dbExport(context.Background(), src, first, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestDB(t)
+	if err := dbImport(context.Background(), dst, first, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbExport(context.Background(), dst, second, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := withoutIDs(second), withoutIDs(first); got != want {
+		t.Errorf("round trip mismatch:\n got: %s\nwant: %s", got, want)
+	}
+
+	reordered := filepath.Join(dir, "reordered.csv")
+	_ = os.WriteFile(reordered, []byte("\ufeffDate,Comment,Cost,Category,Notes\n"+
+		"2023-10-01,\"lunch, drinks, and tip\",42.50,restaurants,\n2023-10-02,\"lunch, drinks, and tip\",42.50,restaurants,x\n"), 0o600)
+	if err := dbImport(context.Background(), dst, reordered, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbExport(context.Background(), dst, third, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := withoutIDs(third), withoutIDs(first); got != want {
+		t.Errorf("import of reordered columns mismatch:\n got: %s\nwant: %s", got, want)
+	}
+
+	missing := filepath.Join(dir, "missing.csv")
+	_ = os.WriteFile(missing, []byte("id,cost,category,comment\n1,2,new,\n"), 0o600)
+	err := dbImport(context.Background(), dst, missing, importOptions{confirm: confirmed, columns: exportColumns})
+	if !errors.Is(err, errUser) {
+		t.Errorf("expected a user error importing a file without a date column, got %v", err)
+	}
+}
+
+func Test_dbExportCancelled(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 4250, "restaurants", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := dbExport(ctx, db, path, "", "", centsDigits); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error of a cancelled export, got %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no file of a cancelled export, got %v", err)
+	}
+}
+
+func Test_dbImportReplacesAtomically(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "old", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	countRows := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.csv")
+	_ = os.WriteFile(broken, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n2,oops,new,,2023-01-03\n"), 0o600)
+	if err := dbImport(context.Background(), db, broken, importOptions{confirm: confirmed, columns: exportColumns}); err == nil {
+		t.Fatal("expected an error importing a malformed file")
+	}
+	if n := countRows(); n != 1 {
+		t.Errorf("malformed import changed the database, got %d rows, want 1", n)
+	}
+
+	valid := filepath.Join(dir, "valid.csv")
+	_ = os.WriteFile(valid, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n2,3,new,,2023-01-03\n"), 0o600)
+	if err := dbImport(context.Background(), db, valid, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(); n != 2 {
+		t.Errorf("import did not replace the data, got %d rows, want 2", n)
+	}
+}
+
+func Test_dbInitSchemaVersion(t *testing.T) {
+	db := newTestDB(t)
+	if version, err := userVersion(db); err != nil || version != schemaVersion {
+		t.Fatalf("got schema version %d (%v), want %d", version, err, schemaVersion)
+	}
+	if _, err := db.Exec("DROP INDEX idx_transactions_date"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbInit(db); err != nil { // up to date, so no DDL
+		t.Fatal(err)
+	}
+	var indexes int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_transactions_date'").Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 0 {
+		t.Error("expected an up to date database to be left alone, got the dropped index re-created")
+	}
+}
+
+func Test_migrateCostToCents(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "liet.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+	CREATE TABLE transactions (id INTEGER PRIMARY KEY AUTOINCREMENT, cost REAL NOT NULL, category TEXT, comment TEXT, date TEXT NOT NULL);
+	INSERT INTO transactions (cost, category, comment, date) VALUES (1234.57, 'rent', '', '2023-01-01'), (0.1, NULL, '', '2023-01-02');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dbInit(db); err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total cents
+	for _, s := range summaries {
+		total += s.totalCost
+	}
+	if total != 123467 {
+		t.Errorf("got total %v, want 1234.67", total)
+	}
+
+	failing, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "liet.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failing.Close()
+	failing.SetMaxOpenConns(1) // the connection a failed migration would leave in a transaction
+	_, err = failing.Exec(`
+	CREATE TABLE transactions (id INTEGER PRIMARY KEY AUTOINCREMENT, cost REAL NOT NULL, category TEXT, comment TEXT, date TEXT NOT NULL);
+	CREATE TABLE transactions_cents (id INTEGER PRIMARY KEY);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbInit(failing); err == nil {
+		t.Fatal("expected the migration to fail with the transactions_cents table taken")
+	}
+	tx, err := failing.Begin()
+	if err != nil {
+		t.Fatalf("expected the failed migration to be rolled back, got %v", err)
+	}
+	_ = tx.Rollback()
+}
+
+func Test_jsonRoundTrip(t *testing.T) {
+	src := newTestDB(t)
+	if _, err := insertTransaction(src, 4250, "restaurants", "lunch, drinks, and tip", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(src, 300, "", "", "2023-10-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.json"), filepath.Join(dir, "second.json")
+	if err := dbExportJSON(src, first, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	dst := newTestDB(t)
+	if err := dbImportJSON(context.Background(), dst, first, importOptions{confirm: confirmed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbExportJSON(dst, second, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := os.ReadFile(first)
+	got, _ := os.ReadFile(second)
+	if string(got) != string(want) {
+		t.Errorf("round trip mismatch:\n got: %s\nwant: %s", got, want)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	_ = os.WriteFile(invalid, []byte(`[{"cost": 1, "date": "2023-10-01"}, {"cost": 1, "date": "01/10/2023"}]`), 0o600)
+	if err := dbImportJSON(context.Background(), dst, invalid, importOptions{confirm: confirmed}); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error importing an invalid date, got %v", err)
+	}
+}
+
+func Test_resolveDate(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"today", "2023-10-01"},
+		{"Yesterday", "2023-09-30"},
+		{"3 days ago", "2023-09-28"},
+		{"1 day ago", "2023-09-30"},
+		{"0 days ago", "2023-10-01"},
+		{"2023-01-15", "2023-01-15"},
+		{"-2 days ago", "-2 days ago"},
+		{"tomorrow", "tomorrow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			if got := resolveDate(tt.date, now); got != tt.want {
+				t.Errorf("resolveDate(%q) = %q, want %q", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseAmount(t *testing.T) {
+	tests := []struct {
+		amount  string
+		want    cents
+		wantErr bool
+	}{
+		{"10.50", 1050, false},
+		{"10", 1000, false},
+		{"$10.50", 1050, false},
+		{"$1,234.50", 123450, false},
+		{"€9,99", 999, false},
+		{"9,99€", 999, false},
+		{"1.234,50 €", 123450, false},
+		{"1,234", 123400, false}, // ambiguous: a comma followed by three digits is a thousands separator
+		{"1.234", 123, false},    // ambiguous: a single dot is always a decimal separator
+		{"1,5", 150, false},
+		{"1.234.567", 123456700, false},
+		{"1,234,567.89", 123456789, false},
+		{"1'234.50", 123450, false},
+		{"-$5", -500, false},
+		{"abc", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount, func(t *testing.T) {
+			got, err := parseAmount(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAmount(%q) error = %v, wantErr %v", tt.amount, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseAmount(%q) = %v, want %v", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeCategories(t *testing.T) {
+	db := newTestDB(t)
+	for _, category := range []string{"food", "restaurants", "dining", "rent"} {
+		if _, err := insertTransaction(db, 1, category, "", "2023-01-01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mergeCategories(db, []string{"food", "restaurants", "dining"}, "dining"); err != nil {
+		t.Fatal(err)
+	}
+	var dining, rent int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE category = 'dining'").Scan(&dining); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE category = 'rent'").Scan(&rent); err != nil {
+		t.Fatal(err)
+	}
+	if dining != 3 || rent != 1 {
+		t.Errorf("got %d dining and %d rent transactions, want 3 and 1", dining, rent)
+	}
+}
+
+func Test_backupDatabase(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "food", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := backupDatabase(db, dir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := backupDatabase(db, dir, ""); !errors.Is(err, errUser) {
+		t.Errorf("expected an user error overwriting a backup, got %v", err)
+	}
+
+	backup, err := sql.Open("sqlite", filepath.Join(dir, "liet-backup-"+time.Now().Format("2006-01-02")+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = backup.Close() }()
+	var n int
+	if err := backup.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d transactions in the backup, want 1", n)
+	}
+}
+
+func Test_resolveDatabasePath(t *testing.T) {
+	if got := resolveDatabasePath("config.db", ""); got != "config.db" {
+		t.Errorf("got %q, want the configured database", got)
+	}
+	t.Setenv(databaseEnv, "env.db")
+	if got := resolveDatabasePath("config.db", ""); got != "env.db" {
+		t.Errorf("got %q, want the env var database", got)
+	}
+	if got := resolveDatabasePath("config.db", "flag.db"); got != "flag.db" {
+		t.Errorf("got %q, want the flag database", got)
+	}
+}
+
+func Test_runZeroCost(t *testing.T) {
+	db := newTestDB(t)
+	a, f := parse([]string{"0", "freebie"})
+	if err := run(context.Background(), db, a, f, userConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE cost = 0 AND category = 'freebie'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d zero cost transactions, want 1", n)
+	}
+}
+
+func Test_levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"food", "food", 0},
+		{"", "food", 4},
+		{"grocerys", "groceries", 2},
+		{"rent", "rnet", 2},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_confirmCategory(t *testing.T) {
+	db := newTestDB(t)
+	for _, category := range []string{"groceries", "rent", "transport"} {
+		if _, err := insertTransaction(db, 1000, category, "", "2023-10-01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	declined := func(string) bool { return false }
+	tests := []struct {
+		category string
+		confirm  func(string) bool
+		want     string
+	}{
+		{"grocerys", confirmed, "groceries"},
+		{"grocerys", declined, "grocerys"},
+		{"Rent", confirmed, "rent"},
+		{"rent", declined, "rent"},
+		{"health", confirmed, "health"},
+		{"", confirmed, ""},
+	}
+	for _, tt := range tests {
+		got, err := confirmCategory(db, tt.category, tt.confirm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("confirmCategory(%q) = %q, want %q", tt.category, got, tt.want)
+		}
+	}
+}
+
+func Test_runDefaultCategory(t *testing.T) {
+	db := newTestDB(t)
+	for _, cmdline := range [][]string{{"3"}, {"4", "food"}} {
+		a, f := parse(cmdline)
+		if err := run(context.Background(), db, a, f, userConfig{defaultCategory: "misc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, f := parse([]string{"5"})
+	if err := run(context.Background(), db, a, f, userConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	rows, err := db.Query("SELECT COALESCE(category, 'NULL') FROM transactions ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, category)
+	}
+	if want := []string{"misc", "food", "NULL"}; !slices.Equal(got, want) {
+		t.Errorf("got categories %q, want %q", got, want)
+	}
+}
+
+func Test_runReceipt(t *testing.T) {
+	db := newTestDB(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	_ = os.WriteFile("dinner.pdf", []byte("%PDF"), 0o600)
+
+	a, f := parse([]string{"42.5", "restaurants", "-r", "dinner.pdf"})
+	if err := run(context.Background(), db, a, f, userConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := getTransaction(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "dinner.pdf"); got.receipt != want {
+		t.Errorf("got receipt %q, want the absolute path %q", got.receipt, want)
+	}
+
+	a, f = parse([]string{"3", "coffee", "-r", "missing.pdf"})
+	if err := run(context.Background(), db, a, f, userConfig{}); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error attaching a missing receipt, got %v", err)
+	}
+	if _, err := getTransaction(db, 2); !errors.Is(err, errUser) {
+		t.Errorf("expected no transaction recorded with a missing receipt, got %v", err)
+	}
+	if _, err := insertTransaction(db, 300, "coffee", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := openReceipt(db, 2); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error opening the receipt of a transaction without one, got %v", err)
+	}
+}
+
+func Test_runSplit(t *testing.T) {
+	db := newTestDB(t)
+	costs := func() map[string]cents {
+		t.Helper()
+		summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]cents{}
+		for _, s := range summaries {
+			got[s.categoryName()] = s.totalCost
+		}
+		return got
+	}
+
+	a, f := parse([]string{"50", "-split", "30 groceries;20 eating out", "-c", "market", "-force"})
+	if err := run(context.Background(), db, a, f, userConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	a, f = parse([]string{"100", "-split", "33.33 rent;33.33 rent;33.33 utilities", "-force"})
+	if err := run(context.Background(), db, a, f, userConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]cents{"groceries": 3000, "eating out": 2000, "rent": 6666, "utilities": 3334}
+	if got := costs(); !maps.Equal(got, want) {
+		t.Errorf("got costs %v, want %v", got, want)
+	}
+
+	a, f = parse([]string{"60", "-split", "30 groceries;20 household", "-force"})
+	if err := run(context.Background(), db, a, f, userConfig{}); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error of a split not adding up to the cost, got %v", err)
+	}
+	if got := costs(); !maps.Equal(got, want) {
+		t.Errorf("a rejected split changed the costs, got %v, want %v", got, want)
+	}
+	var split splitFlag
+	for _, invalid := range []string{"30 groceries", "30 groceries;twenty household", "30 groceries;-20 household"} {
+		if err := split.Set(invalid); !errors.Is(err, errUser) {
+			t.Errorf("splitFlag.Set(%q) = %v, want a user error", invalid, err)
+		}
+	}
+}
+
+func Test_runCurrency(t *testing.T) {
+	db := newTestDB(t)
+	c := userConfig{baseCurrency: "EUR"}
+	for _, args := range [][]string{{"20", "taxi"}, {"25", "taxi", "-cur", "usd"}, {"5", "taxi", "-cur", "EUR"}} {
+		a, f := parse(args)
+		if err := run(context.Background(), db, a, f, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, want := range map[int]string{1: "", 2: "USD", 3: ""} {
+		got, err := getTransaction(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.currency != want {
+			t.Errorf("transaction #%d has currency %q, want %q", id, got.currency, want)
+		}
+	}
+	summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].totalCost != 2500 {
+		t.Errorf("got summaries %+v, want only the 25.00 taxi rides in the base currency", summaries)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := dbExport(context.Background(), db, path, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	imported := newTestDB(t)
+	if err := dbImport(context.Background(), imported, path, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getTransaction(imported, 2); err != nil || got.currency != "USD" {
+		t.Errorf("got %+v, %v after the CSV round trip, want the USD currency kept", got, err)
+	}
+
+	a, f := parse([]string{"3", "coffee", "-cur", "dollars"})
+	if err := run(context.Background(), db, a, f, c); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error of an invalid currency, got %v", err)
+	}
+}
+
+func Test_archiveTransactions(t *testing.T) {
+	db := newTestDB(t)
+	err := insertTransactions(context.Background(), db, []transaction{
+		newTransaction(1250, "food", "dinner #rome", "2019-10-01"),
+		newTransaction(300, "food", "", "2023-10-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiveTransactions(db, "2020-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	var hot int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&hot); err != nil || hot != 1 {
+		t.Errorf("got %d transactions (%v) after archiving, want 1", hot, err)
+	}
+	if from, err := transactionsIn(db, "2023-10-01", "2023-10-31"); err != nil || from != "transactions" {
+		t.Errorf("transactionsIn() = %q, %v, want the recent window to skip the archive", from, err)
+	}
+	for window, want := range map[[2]string]cents{{"0000-00-00", "9999-12-31"}: 1550, {"2019-10-01", "2019-10-31"}: 1250} {
+		summaries, err := costAggregration(db, window[0], window[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 || summaries[0].totalCost != want {
+			t.Errorf("got summaries %+v between %s and %s, want %v of food", summaries, window[0], window[1], want)
+		}
+	}
+	var tags int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tags WHERE transaction_id = 1 AND tag = 'rome'").Scan(&tags); err != nil || tags != 1 {
+		t.Errorf("got %d rome tags (%v) of the archived transaction, want 1", tags, err)
+	}
+
+	if err := renameCategory(db, "food", "groceries"); err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].category.String != "groceries" || summaries[0].totalCost != 1550 {
+		t.Errorf("got summaries %+v after renaming, want the archived transaction renamed too", summaries)
+	}
+	if got, err := confirmCategory(db, "grocerie", confirmed); err != nil || got != "groceries" {
+		t.Errorf("confirmCategory() = %q, %v, want the archived category suggested", got, err)
+	}
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = searchTransactions(db, "rome", "")
+	os.Stdout = stdout
+	_ = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bytes.Buffer
+	if _, err := found.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(found.String(), "dinner #rome") {
+		t.Errorf("expected -find to match the archived transaction, got:\n%s", found.String())
+	}
+	if err := updateTransaction(db, 1, map[string]any{"cost": cents(1300)}); err != nil {
+		t.Fatalf("expected editing an archived transaction to work, got %v", err)
+	}
+	if got, err := getTransaction(db, 1); err != nil || got.cost != 1300 {
+		t.Errorf("getTransaction(1) = %v, %v, want the edited archived transaction", got, err)
+	}
+	csvPath := filepath.Join(t.TempDir(), "bank.csv")
+	_ = os.WriteFile(csvPath, []byte("id,cost,category,comment,date\n1,13.00,groceries,dinner #rome,2019-10-01\n"), 0o600)
+	o := importOptions{confirm: confirmed, add: true, dedup: true, columns: exportColumns}
+	if err := dbImport(context.Background(), db, csvPath, o); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, err = costAggregration(db, "0000-00-00", "9999-12-31"); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].totalCost != 1600 {
+		t.Errorf("got summaries %+v after a dedup import, want the archived transaction skipped", summaries)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.csv")
+	if err := dbExport(context.Background(), db, path, "", "", centsDigits); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbImport(context.Background(), db, path, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, err = costAggregration(db, "0000-00-00", "9999-12-31"); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].totalCost != 1600 {
+		t.Errorf("got summaries %+v after importing the export back, want the archived transaction once", summaries)
+	}
+
+	if err := archiveTransactions(db, "2020-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	var archived int
+	if err := db.QueryRow("SELECT id FROM archived_transactions").Scan(&archived); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteTransaction(db, archived); err != nil {
+		t.Fatalf("expected removing an archived transaction to work, got %v", err)
+	}
+	if _, err := getTransaction(db, archived); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error getting the removed archived transaction, got %v", err)
+	}
+
+	if err := archiveTransactions(db, "2020-13-01"); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error of an invalid date, got %v", err)
+	}
+}
+
+func Test_displayDate(t *testing.T) {
+	for _, tt := range []struct {
+		date, layout, want string
+	}{
+		{"2023-10-01", "", "2023-10-01"},
+		{"2023-10-01", "02/01/2006", "01/10/2023"},
+		{"2023-10-01 14:30", "02/01/2006", "01/10/2023 14:30"},
+		{"2023-10-01", "Jan 2, 2006", "Oct 1, 2023"},
+	} {
+		if got := displayDate(tt.date, tt.layout); got != tt.want {
+			t.Errorf("displayDate(%q, %q) = %q, want %q", tt.date, tt.layout, got, tt.want)
+		}
+	}
+	for _, invalid := range []string{"", "DD/MM/YYYY", "15:04"} {
+		if _, err := parseDateLayout(invalid); !errors.Is(err, errUser) {
+			t.Errorf("parseDateLayout(%q) = %v, want a user error", invalid, err)
+		}
+	}
+}
+
+func Test_configureLoggerUnwritableFile(t *testing.T) {
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	notADir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notADir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(logFileEnv, filepath.Join(notADir, "liet.log")) // a directory can't be created under a file
+	t.Setenv(debugEnv, "")
+
+	cleanup, err := configureLogger()
+	if err != nil {
+		t.Fatalf("configureLogger() = %v, want logging disabled without an error", err)
+	}
+	slog.Info("not written anywhere")
+	if err := cleanup(); err != nil {
+		t.Errorf("cleanup() = %v, want no error", err)
+	}
+}
+
+func Test_configureLoggerJSON(t *testing.T) {
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	logFile := filepath.Join(t.TempDir(), "liet.log")
+	t.Setenv(logFileEnv, logFile)
+	t.Setenv(logFormatEnv, "json")
+	t.Setenv(debugEnv, "")
+
+	cleanup, err := configureLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("hello", "answer", 42)
+	if err := cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line struct {
+		Level  string `json:"level"`
+		Msg    string `json:"msg"`
+		Answer int    `json:"answer"`
+	}
+	if err := json.Unmarshal(b, &line); err != nil || line.Level != "INFO" || line.Msg != "hello" || line.Answer != 42 {
+		t.Errorf("got log %q (%v), want a JSON line of the hello message", b, err)
+	}
+}
+
+func Test_parseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"":      slog.LevelInfo,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"Warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+		"-4":    slog.LevelDebug,
+		"8":     slog.LevelError,
+	}
+	for s, want := range tests {
+		got, err := parseLogLevel(s)
+		if err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"verbose", "4.5"} {
+		if _, err := parseLogLevel(s); !errors.Is(err, errUser) {
+			t.Errorf("parseLogLevel(%q) = %v, want a user error", s, err)
+		}
+	}
+}
+
+func Test_askConfirmationInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if askConfirmation(ctx)("Type 'yes' to confirm: ") {
+		t.Error("expected an interrupted prompt not to confirm")
+	}
+
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 100, "groceries", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	a := arguments{cost: 200, costSet: true, category: "grocerys"}
+	if err := run(ctx, db, a, flags{date: "2023-10-02"}, userConfig{}); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error interrupting the category prompt, got %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil || n != 1 {
+		t.Errorf("got %d transactions (%v) after the interrupted prompt, want nothing recorded", n, err)
+	}
+}
+
+func Test_checkFutureDate(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, date := range []string{"2203-10-01", "2023-10-02", "2023-10-02 08:00"} {
+		if err := checkFutureDate(date, now); !errors.Is(err, errUser) {
+			t.Errorf("checkFutureDate(%q) = %v, want a user error", date, err)
+		}
+	}
+	for _, date := range []string{"2023-10-01", "2023-10-01 23:59", "2023-09-30", ""} {
+		if err := checkFutureDate(date, now); err != nil {
+			t.Errorf("checkFutureDate(%q) = %v, want no error", date, err)
+		}
+	}
+
+	db := newTestDB(t)
+	a := arguments{cost: 100, costSet: true, category: "food"}
+	if err := run(context.Background(), db, a, flags{date: "2203-10-01"}, userConfig{}); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error recording a future date, got %v", err)
+	}
+	if err := run(context.Background(), db, a, flags{date: "2203-10-01", force: true}, userConfig{}); err != nil {
+		t.Errorf("expected a forced future date to be recorded, got %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil || n != 1 {
+		t.Errorf("got %d transactions (%v), want the forced one only", n, err)
+	}
+}
+
+func Test_parseAmountRange(t *testing.T) {
+	_, f := parse([]string{"-l", "-min", "100"})
+	if !f.list.set || !f.minCost.set || f.minCost.amount != 10000 || f.maxCost.set {
+		t.Errorf("parse(-l -min 100) = list %+v, min %+v, max %+v, want only a minimum of 100", f.list, f.minCost, f.maxCost)
+	}
+	_, f = parse([]string{"-l", "5", "-min", "-50", "-max", "12.5"})
+	if f.list.limit != 5 || f.minCost.amount != -5000 || !f.maxCost.set || f.maxCost.amount != 1250 {
+		t.Errorf("parse(-l 5 -min -50 -max 12.5) = list %+v, min %+v, max %+v", f.list, f.minCost, f.maxCost)
+	}
+}
+
+func Test_runBareStats(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline []string
+		want    string
+	}{
+		{[]string{"-w"}, ""},
+		{[]string{"-w", "-format", "md"}, ""},
+		{[]string{"-w", "last week"}, "last week"},
+		{[]string{"-w=monthly", "-counts"}, "monthly"},
+	} {
+		if _, f := parse(tt.cmdline); !f.stats.set || f.stats.window != tt.want {
+			t.Errorf("parse(%q) = %+v, want the %q stats", tt.cmdline, f.stats, tt.want)
+		}
+	}
+
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 4250, "restaurants", "", time.Now().Format("2006-01-02")); err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	a, f := parse([]string{"-w"})
+	err = run(context.Background(), db, a, f, userConfig{})
+	os.Stdout = stdout
+	_ = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "restaurants") || !strings.Contains(out.String(), "42.50") {
+		t.Errorf("expected a bare -w to show this month, got:\n%s", out.String())
+	}
+}
+
+func Test_numberFormat(t *testing.T) {
+	tests := []struct {
+		example string
+		cost    cents
+		want    string
+	}{
+		{"1234.56", 123456789, "1234567.89"},
+		{"1,234.56", 123456789, "1,234,567.89"},
+		{"1.234,56", -123456789, "-1.234.567,89"},
+		{"1 234,56", 99999, "999,99"},
+		{"1'234.56", 100000, "1'000.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.example, func(t *testing.T) {
+			n, err := parseNumberFormat(tt.example)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.format(tt.cost); got != tt.want {
+				t.Errorf("format(%d) = %q, want %q", tt.cost, got, tt.want)
+			}
+		})
+	}
+	for _, invalid := range []string{"", "1234", "1,234,56", "12.34", "1234.5678"} {
+		if _, err := parseNumberFormat(invalid); !errors.Is(err, errUser) {
+			t.Errorf("parseNumberFormat(%q) = %v, want an user error", invalid, err)
+		}
+	}
+}
+
+func Test_decimals(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "liet.conf")
+	_ = os.WriteFile(configPath, []byte("decimals=0\nnumber_format=1,234.56\n"), 0o600)
+	t.Setenv(configFileEnv, configPath)
+	c, err := loadUserConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cost       cents
+		want       string
+		wantExport string
+	}{
+		{123456789, "1,234,568", "1234567.89"}, // the export keeps the cents
+		{150000, "1,500", "1500"},
+		{-150, "-2", "-1.50"},
+		{-40, "0", "-0.40"},
+	}
+	for _, tt := range tests {
+		if got := c.numbers.format(tt.cost); got != tt.want {
+			t.Errorf("format(%d) = %q, want %q", tt.cost, got, tt.want)
+		}
+		if got := exportCost(tt.cost, c.decimals); got != tt.wantExport {
+			t.Errorf("exportCost(%d, %d) = %q, want %q", tt.cost, c.decimals, got, tt.wantExport)
+		}
+	}
+	if got := (numberFormat{dropped: 1}).format(12345); got != "123.5" {
+		t.Errorf("format(12345) with one decimal = %q, want 123.5", got)
+	}
+	for _, invalid := range []string{"", "3", "-1", "two"} {
+		if _, err := parseDecimals(invalid); !errors.Is(err, errUser) {
+			t.Errorf("parseDecimals(%q) = %v, want a user error", invalid, err)
+		}
+	}
+}
+
+func Test_loadUserConfigWarnsUnknownKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "liet.conf")
+	_ = os.WriteFile(configPath, []byte("databse=/tmp/typo.db\ncurrency=€\n"), 0o600)
+	t.Setenv(configFileEnv, configPath)
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	if _, err := loadUserConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "Unknown config key") || !strings.Contains(logs.String(), "key=databse") {
+		t.Errorf("expected a warning about the databse key, got logs: %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "key=currency") {
+		t.Errorf("unexpected warning about the currency key, got logs: %s", logs.String())
+	}
+
+	_ = os.WriteFile(configPath, []byte("currency=€\n[budget]\ndatabase=/tmp/typo.db\nfood=100\n[budgets]\nrent=900\n"), 0o600)
+	logs.Reset()
+	c, err := loadUserConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.databasePath == "/tmp/typo.db" || c.currency != "€" || c.budgets["rent"] != 90000 || len(c.budgets) != 1 {
+		t.Errorf("expected the keys of the unknown section to be skipped, got %+v", c)
+	}
+	if strings.Count(logs.String(), "Unknown config section") != 1 || !strings.Contains(logs.String(), "section=budget") {
+		t.Errorf("expected a single warning about the budget section, got logs: %s", logs.String())
+	}
+}
+
+func Test_loadUserConfigDefaultStats(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	for _, tt := range []struct {
+		value string
+		want  string
+		warns bool
+	}{
+		{"lastmonth", "lastmonth", false},
+		{"Last Month", "Last Month", false},
+		{"top5", "top5", false},
+		{"tag:rome", "tag:rome", false},
+		{"2023-01-01:2023-03-31", "2023-01-01:2023-03-31", false},
+		{"lastmonht", "", true},
+		{"top0", "", true},
+	} {
+		logs.Reset()
+		configPath := filepath.Join(t.TempDir(), "liet.conf")
+		_ = os.WriteFile(configPath, []byte("default_stats="+tt.value+"\n"), 0o600)
+		t.Setenv(configFileEnv, configPath)
+		c, err := loadUserConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.defaultStats != tt.want {
+			t.Errorf("default_stats=%s loaded as %q, want %q", tt.value, c.defaultStats, tt.want)
+		}
+		if warned := strings.Contains(logs.String(), "Unknown default_stats"); warned != tt.warns {
+			t.Errorf("default_stats=%s warned %v, want %v, got logs: %s", tt.value, warned, tt.warns, logs.String())
+		}
+	}
+}
+
+func Test_insertTransactions(t *testing.T) {
+	db := newTestDB(t)
+	err := insertTransactions(context.Background(), db, []transaction{
+		newTransaction(1250, "food", "dinner #rome", "2023-10-01 20:30"),
+		newTransaction(300, " ", "", "2023-10-02"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := getTransaction(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.cost != 1250 || got.category.String != "food" || got.date != "2023-10-01 20:30" {
+		t.Errorf("got first transaction %+v", got)
+	}
+	if got, err = getTransaction(db, 2); err != nil || got.category.Valid {
+		t.Errorf("got second transaction %+v (%v), want it without a category", got, err)
+	}
+	var tags int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tags WHERE transaction_id = 1 AND tag = 'rome'").Scan(&tags); err != nil || tags != 1 {
+		t.Errorf("got %d rome tags (%v), want 1", tags, err)
+	}
+}
+
+func Test_extractTags(t *testing.T) {
+	got := extractTags("#Trip to #rome, dinner with #friends! #rome # c#")
+	want := []string{"trip", "rome", "friends"}
+	if !slices.Equal(got, want) {
+		t.Errorf("extractTags() = %v, want %v", got, want)
+	}
+}
+
+func Test_dbImportCancelled(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "old", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(t.TempDir(), "valid.csv")
+	_ = os.WriteFile(valid, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n2,3,new,,2023-01-03\n"), 0o600)
+
+	var (
+		question string
+		writeErr error
+	)
+	declined := func(q string) bool {
+		question = q
+		_, writeErr = insertTransaction(db, 5, "meanwhile", "", "2023-01-04") // the database is not locked while asking
+		return false
+	}
+	if err := dbImport(context.Background(), db, valid, importOptions{confirm: declined, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(question, "1 current transactions with the 2") {
+		t.Errorf("expected the confirmation to mention 1 deleted and 2 imported transactions, got %q", question)
+	}
+	if writeErr != nil {
+		t.Errorf("failed to write to the database while asking for confirmation: %v", writeErr)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE category = 'old'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("cancelled import changed the database, got %d old rows, want 1", n)
+	}
+}
+
+func Test_dbImportInterrupted(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "old", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(t.TempDir(), "valid.csv")
+	_ = os.WriteFile(valid, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n2,3,new,,2023-01-03\n"), 0o600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dbImport(ctx, db, valid, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	var categories []string
+	rows, err := db.Query("SELECT category FROM transactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handleErrClose(rows.Close)
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			t.Fatal(err)
+		}
+		categories = append(categories, c)
+	}
+	if !slices.Equal(categories, []string{"old"}) {
+		t.Errorf("interrupted import changed the database, got categories %v, want [old]", categories)
+	}
+}
+
+func Test_dbImportDryRun(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "old", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "import.csv")
+	_ = os.WriteFile(file, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n2,abc,new,,2023-01-03\n3,4,new,,2023-13-01\n"), 0o600)
+
+	notAsked := func(string) bool { t.Error("dry run asked for confirmation"); return false }
+	if err := dbImport(context.Background(), db, file, importOptions{confirm: notAsked, dryRun: true, columns: exportColumns}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE category = 'old'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("dry run changed the database, got %d old rows, want 1", n)
+	}
+
+	transactions, err := readCSV(file, exportColumns, nil)
+	if len(transactions) != 1 {
+		t.Errorf("expected 1 valid transaction, got %d", len(transactions))
+	}
+	if !errors.Is(err, errUser) || !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected the invalid lines 2 and 3 to be reported, got %v", err)
+	}
+}
+
+func Test_dbImportBankFile(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 1, "old", "", "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	bank := filepath.Join(t.TempDir(), "bank.csv")
+	_ = os.WriteFile(bank, []byte("Date,Description,Amount\n01/10/2023,Coffee shop,4.20\n15/10/2023,Bakery,2.5\n"), 0o600)
+
+	var columns columnsFlag
+	if err := columns.Set("date=0,cost=2,comment=1"); err != nil {
+		t.Fatal(err)
+	}
+	columns.columns.dateFormat = "DD/MM/YYYY"
+	if err := dbImport(context.Background(), db, bank, importOptions{confirm: confirmed, add: true, columns: columns.columns}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT date, cost, COALESCE(comment, '') FROM transactions ORDER BY date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var date, comment string
+		var cost cents
+		if err := rows.Scan(&date, &cost, &comment); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.TrimSpace(date+" "+cost.String()+" "+comment))
+	}
+	want := []string{"2023-01-01 0.01", "2023-10-01 4.20 Coffee shop", "2023-10-15 2.50 Bakery"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected the bank transactions added to the current ones %q, got %q", want, got)
+	}
+
+	for _, invalid := range []string{"cost=2", "date=0,cost=x", "date=0,cost=2,amount=3"} {
+		if err := columns.Set(invalid); !errors.Is(err, errUser) {
+			t.Errorf("expected a user error for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func Test_dbImportDedup(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 420, "coffee", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "october.csv")
+	_ = os.WriteFile(file, []byte("id,cost,category,comment,date\n1,4.20,coffee,,2023-10-01\n2,4.20,coffee,,2023-10-02\n"), 0o600)
+
+	for range 2 { // importing the same file again adds nothing
+		o := importOptions{confirm: confirmed, add: true, dedup: true, columns: exportColumns}
+		if err := dbImport(context.Background(), db, file, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected the duplicate transactions to be skipped, got %d rows, want 2", n)
+	}
+}
+
+func Test_dbImportDedupRecorded(t *testing.T) {
+	db := newTestDB(t)
+	today := time.Now().Format("2006-01-02")
+	for _, date := range []string{"2023-10-01", today} {
+		a := arguments{cost: 420, costSet: true, category: "coffee"}
+		if _, err := recordTransaction(db, a, flags{date: date}, userConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var untimed int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions WHERE time IS NULL").Scan(&untimed); err != nil || untimed != 1 {
+		t.Errorf("got %d transactions (%v) without a time of day, want the back-dated one only", untimed, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "bank.csv")
+	_ = os.WriteFile(file, []byte("2023-10-01,4.20,coffee\n"+today+",4.20,coffee\n"), 0o600)
+	columns := csvColumns{date: 0, cost: 1, category: 2, comment: -1, currency: -1, dateFormat: "YYYY-MM-DD"}
+	o := importOptions{confirm: confirmed, add: true, dedup: true, columns: columns}
+	if err := dbImport(context.Background(), db, file, o); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected the bank rows of the recorded transactions to be skipped, got %d rows, want 2", n)
+	}
+}
+
+func Test_dbExportLedger(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := insertTransaction(db, 4250, "restaurants", "lunch", "2023-10-02"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(db, -250000, "salary", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(db, 300, "", "", "2023-10-03"); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "liet.journal")
+	if err := dbExportLedger(db, file, "", "", "€"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2023-10-01 salary
+    Income:salary  -€2500.00
+    Assets:Cash
+
+2023-10-02 restaurants
+    ; lunch
+    Expenses:restaurants  €42.50
+    Assets:Cash
+
+2023-10-03 Unknown
+    Expenses:Unknown  €3.00
+    Assets:Cash
+
+`
+	if string(got) != want {
+		t.Errorf("unexpected ledger export, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_timeOfDay(t *testing.T) {
+	db := newTestDB(t)
+	morning, err := insertTransaction(db, 250, "coffee", "", "2023-10-01 08:15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(db, 300, "coffee", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getTransaction(db, int(morning))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.date != "2023-10-01 08:15" {
+		t.Errorf("expected the time of day with the date, got %q", got.date)
+	}
+	summaries, err := costAggregration(db, "2023-10-01", "2023-10-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].totalCost != 550 {
+		t.Errorf("expected the stats to use the date only, got %v", summaries)
+	}
+
+	for _, invalid := range []string{"2023-10-01 25:00", "2023-10-01 morning", "01/10/2023 08:15"} {
+		if _, _, err := parseDateTime(invalid); !errors.Is(err, errUser) {
+			t.Errorf("expected a user error for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func Test_readonly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a?b#c", "liet.db") // not a query nor a fragment of the file URI
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", databaseDSN(path, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dbInit(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTransaction(db, 100, "food", "", "2023-10-01"); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	ro, err := sql.Open("sqlite", databaseDSN(path, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := checkSchema(ro, path); err != nil {
+		t.Fatalf("expected an up to date database to open read only, got %v", err)
+	}
+	if _, err := costAggregration(ro, "0000-00-00", "9999-12-31"); err != nil {
+		t.Errorf("expected the stats to work read only, got %v", err)
+	}
+	if _, err := insertTransaction(ro, 100, "food", "", "2023-10-02"); err == nil {
+		t.Error("expected inserting into a read only database to fail")
+	}
+	csvPath := filepath.Join(t.TempDir(), "import.csv")
+	_ = os.WriteFile(csvPath, []byte("id,cost,category,comment,date\n1,2,new,,2023-01-02\n"), 0o600)
+	o := importOptions{dryRun: true, dedup: true, add: true, columns: exportColumns}
+	if err := dbImport(context.Background(), ro, csvPath, o); err != nil {
+		t.Errorf("expected a dry run import to work read only, got %v", err)
+	}
+
+	old := filepath.Join(t.TempDir(), "old.db")
+	oldDB, err := sql.Open("sqlite", databaseDSN(old, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oldDB.Close()
+	if _, err := oldDB.Exec("CREATE TABLE transactions (id INTEGER PRIMARY KEY); PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSchema(oldDB, old); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error for an out of date database, got %v", err)
+	}
+	if err := checkSchema(oldDB, filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error for a missing database, got %v", err)
+	}
+
+	if what := mutation(arguments{}, flags{stats: statsFlag{set: true, window: "monthly"}}); what != "" {
+		t.Errorf("expected the stats to be allowed, got %q", what)
+	}
+	if what := mutation(arguments{}, flags{importCSV: "a.csv", dryRun: true}); what != "" {
+		t.Errorf("expected a dry run import to be allowed, got %q", what)
+	}
+	for _, f := range []flags{{undo: true}, {importCSV: "a.csv"}, {recur: "apply"}, {yeet: yeetFlag{set: true}}} {
+		if mutation(arguments{}, f) == "" {
+			t.Errorf("expected %+v to be rejected", f)
+		}
+	}
+}
+
+func Benchmark_dbInit(b *testing.B) {
+	db := newTestDB(b)
+	for b.Loop() {
+		if err := dbInit(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_dbImport(b *testing.B) {
+	var csv strings.Builder
+	csv.WriteString("id,cost,category,comment,date\n")
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 50_000 {
+		fmt.Fprintf(&csv, "%d,%d.%02d,category%d,comment %d,%s\n", i, i%500, i%100, i%20, i, start.AddDate(0, 0, i%730).Format("2006-01-02"))
+	}
+	path := filepath.Join(b.TempDir(), "import.csv")
+	if err := os.WriteFile(path, []byte(csv.String()), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	b.Cleanup(func() { os.Stdout = stdout })
+
+	db := newTestDB(b)
+	for b.Loop() {
+		if err := dbImport(context.Background(), db, path, importOptions{confirm: confirmed, columns: exportColumns}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
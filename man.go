@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// hiddenFlags are left out of the usage and the completion scripts, e.g. the ones meant for packagers.
+var hiddenFlags = []string{"man"}
+
+// printDefaults prints the usage of the flags like flagset.PrintDefaults, without the hidden ones.
+func printDefaults(flagset *flag.FlagSet) {
+	visible := flag.NewFlagSet(flagset.Name(), flag.ContinueOnError)
+	visible.SetOutput(flagset.Output())
+	flagset.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(hiddenFlags, f.Name) {
+			return
+		}
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue // not the value parsed so far
+	})
+	visible.PrintDefaults()
+}
+
+// printManPage writes the liet(1) man page, in roff, describing the flags of the flagset and the stats views, e.g.
+// liet -man > /usr/share/man/man1/liet.1.
+func printManPage(w io.Writer, flagset *flag.FlagSet) {
+	fmt.Fprintf(w, ".TH LIET 1 \"\" \"liet %s\" \"User Commands\"\n", roffEscape(appVersion()))
+	fmt.Fprint(w, `.SH NAME
+liet \- track how expensive your life is from the terminal
+.SH SYNOPSIS
+.B liet
+[\fIcost\fR [\fIcategory\fR] [\fIflags\fR] | \fIflags\fR]
+.SH DESCRIPTION
+.B liet
+records the transactions given as a cost and an optional category, and shows what they add up to with the
+.B \-w
+stats views.
+Income is recorded with
+.BR \-income .
+.SH OPTIONS
+`)
+	flagset.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(hiddenFlags, f.Name) {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintln(w, ".TP")
+		if name == "" {
+			fmt.Fprintf(w, ".B \\-%s\n", roffEscape(f.Name))
+		} else {
+			fmt.Fprintf(w, ".BI \"\\-%s \" %s\n", roffEscape(f.Name), roffEscape(name))
+		}
+		for line := range strings.Lines(usage) {
+			fmt.Fprintln(w, roffEscape(strings.TrimSpace(line)))
+		}
+	})
+
+	fmt.Fprintln(w, ".SH STATS")
+	fmt.Fprintln(w, "The views of")
+	fmt.Fprintln(w, ".BR \\-w ,")
+	fmt.Fprintln(w, "spaces and dashes are ignored in their names:")
+	for _, cmd := range slices.Sorted(maps.Keys(statsCommands())) {
+		fmt.Fprintln(w, ".TP")
+		h, ok := statsDescriptions[cmd]
+		if !ok {
+			fmt.Fprintf(w, ".B %s\n", roffEscape(string(cmd)))
+			continue
+		}
+		fmt.Fprintf(w, ".BR %s \" or \" \"%s\"\n%s\n", roffEscape(string(cmd)), roffEscape(h[0]), roffEscape(h[1]))
+	}
+	for _, p := range statsPatterns {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", roffEscape(p[0]))
+		if p[1] != "" {
+			fmt.Fprintf(w, "%s, e.g. %s\n", roffEscape(p[2]), roffEscape(p[1]))
+			continue
+		}
+		fmt.Fprintln(w, roffEscape(p[2]))
+	}
+
+	fmt.Fprintf(w, `.SH ENVIRONMENT
+.TP
+.B %s
+Path of the database, takes precedence over the config file
+.TP
+.B %s
+Path of the config file
+.TP
+.B %s
+Passphrase of the encrypted database, asked for when not set
+.TP
+.B %s
+Level of the logs, debug, info, warn or error
+.TP
+.B %s
+Path of the log file
+.TP
+.B %s
+Format of the logs, text or json
+.TP
+.B %s
+Logs to the standard error when set
+.SH FILES
+.TP
+.I ~/%s
+The default database
+.TP
+.I ~/%s
+The default config file
+.TP
+.I ~/%s
+The default log file
+.SH SEE ALSO
+https://github.com/luisferreira32/liet
+`, databaseEnv, configFileEnv, passphraseEnv, logLevelEnv, logFileEnv, logFormatEnv, debugEnv,
+		roffEscape(defaultDatabaseFile), roffEscape(defaultConfigFile), roffEscape(defaultLogFile))
+}
+
+// roffEscape escapes the backslashes, dashes and leading dots or quotes that roff would take as its own.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
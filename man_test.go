@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func Test_printManPage(t *testing.T) {
+	flagset := flag.NewFlagSet("liet", flag.ContinueOnError)
+	flagset.String("db", "", "Path of the database to use for this run")
+	flagset.Bool("force", false, "Skip the confirmation prompts,\ne.g. of -yeet")
+	flagset.Bool("man", false, "Print the man page")
+
+	var b strings.Builder
+	printManPage(&b, flagset)
+	got := b.String()
+	for _, want := range []string{
+		".TH LIET 1",
+		".BI \"\\-db \" string\nPath of the database to use for this run\n",
+		".B \\-force\nSkip the confirmation prompts,\ne.g. of \\-yeet\n",
+		".BR lastmonth \" or \" \"last month\"\n",
+		".B tag:name\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("man page is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "\\-man") {
+		t.Errorf("man page has the hidden -man flag:\n%s", got)
+	}
+}
+
+func Test_roffEscape(t *testing.T) {
+	tests := map[string]string{
+		"-d 2023-10-01": `\-d 2023\-10\-01`,
+		`C:\liet`:       `C:\eliet`,
+		".liet.db":      `\&.liet.db`,
+		"'quoted'":      `\&'quoted'`,
+		"plain":         "plain",
+	}
+	for s, want := range tests {
+		if got := roffEscape(s); got != want {
+			t.Errorf("roffEscape(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
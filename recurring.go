@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cadences are the valid periods of a recurring transaction.
+var cadences = []string{"daily", "weekly", "monthly", "yearly"}
+
+// recurringTransaction is the template of a transaction that repeats every cadence since its start date.
+type recurringTransaction struct {
+	id          int
+	cost        cents
+	category    sql.NullString
+	comment     string
+	cadence     string
+	startDate   string
+	lastApplied sql.NullString // date of the last occurrence inserted
+}
+
+// recur runs the -recur action: add a recurring transaction, apply the due ones or list them.
+func recur(db *sql.DB, action, spec, comment, date string) error {
+	switch action {
+	case "add":
+		return addRecurring(db, spec, comment, date)
+	case "apply":
+		return withTx(db, func(tx database) error { return applyRecurring(tx, time.Now()) })
+	case "list":
+		return listRecurring(db)
+	default:
+		return fmt.Errorf("%w: unknown -recur action %q, expecting add, apply or list", errUser, action)
+	}
+}
+
+// addRecurring adds a recurring transaction from a spec as <cost> [<category>] <cadence>, e.g. 850 rent monthly,
+// starting on the date.
+func addRecurring(db database, spec, comment, date string) error {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields) > 3 { //nolint:mnd // cost, category and cadence
+		return fmt.Errorf("%w: invalid recurring transaction %q, expecting <cost> [<category>] <cadence>, e.g. 850 rent monthly", errUser, spec)
+	}
+	cost, err := parseAmount(fields[0])
+	if err != nil {
+		return fmt.Errorf("%w: invalid cost %q in recurring transaction: %w", errUser, fields[0], err)
+	}
+	cadence := strings.ToLower(fields[len(fields)-1])
+	if !slices.Contains(cadences, cadence) {
+		return fmt.Errorf("%w: invalid cadence %q, expecting one of %s", errUser, cadence, strings.Join(cadences, ", "))
+	}
+	var category string
+	if len(fields) == 3 { //nolint:mnd // cost, category and cadence
+		category = fields[1]
+	}
+	date, _, _ = strings.Cut(date, " ") // occurrences have no time of day
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO recurring (cost, category, comment, cadence, start_date) VALUES (?, ?, ?, ?, ?)",
+		cost, sql.NullString{String: category, Valid: category != ""}, comment, cadence, date,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert recurring transaction: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get inserted recurring transaction id: %w", err)
+	}
+	fmt.Printf("Added recurring transaction #%d: %v %s %s since %s, record the due ones with -recur apply\n",
+		id, cost, categoryOrNA(category), cadence, date)
+	return nil
+}
+
+func categoryOrNA(category string) string {
+	if category == "" {
+		return "N/A"
+	}
+	return category
+}
+
+func recurringTransactions(db database) ([]recurringTransaction, error) {
+	rows, err := db.Query("SELECT id, cost, category, COALESCE(comment, ''), cadence, start_date, last_applied FROM recurring ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recurring transactions: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var recurring []recurringTransaction
+	for rows.Next() {
+		var r recurringTransaction
+		if err := rows.Scan(&r.id, &r.cost, &r.category, &r.comment, &r.cadence, &r.startDate, &r.lastApplied); err != nil {
+			return nil, fmt.Errorf("failed to scan recurring transaction: %w", err)
+		}
+		recurring = append(recurring, r)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return recurring, nil
+}
+
+// applyRecurring inserts the occurrences of the recurring transactions that are due up to the given day and were not
+// applied yet.
+func applyRecurring(db database, upTo time.Time) error {
+	recurring, err := recurringTransactions(db)
+	if err != nil {
+		return err
+	}
+
+	until := upTo.Format("2006-01-02")
+	var applied int
+	for _, r := range recurring {
+		start, err := time.Parse("2006-01-02", r.startDate)
+		if err != nil {
+			return fmt.Errorf("invalid start date %q of recurring transaction #%d: %w", r.startDate, r.id, err)
+		}
+		last := ""
+		for n := 0; ; n++ {
+			date := occurrence(start, r.cadence, n).Format("2006-01-02")
+			if date > until {
+				break
+			}
+			if r.lastApplied.Valid && date <= r.lastApplied.String {
+				continue
+			}
+			_, err = insertTransaction(db, r.cost, r.category.String, r.comment, date)
+			if err != nil {
+				return err
+			}
+			last = date
+			applied++
+		}
+		if last == "" {
+			continue
+		}
+		_, err = db.Exec("UPDATE recurring SET last_applied = ? WHERE id = ?", last, r.id)
+		if err != nil {
+			return fmt.Errorf("failed to update recurring transaction #%d: %w", r.id, err)
+		}
+	}
+	fmt.Printf("Added %d recurring transaction(s).\n", applied)
+	return nil
+}
+
+// occurrence returns the nth occurrence of the cadence since start, a day missing in a month, e.g. the 31st of
+// February, falls on the last day of that month.
+func occurrence(start time.Time, cadence string, n int) time.Time {
+	switch cadence {
+	case "daily":
+		return start.AddDate(0, 0, n)
+	case "weekly":
+		return start.AddDate(0, 0, n*daysOfWeek)
+	case "yearly":
+		n *= 12 //nolint:mnd // months in a year
+	}
+	first := time.Date(start.Year(), start.Month()+time.Month(n), 1, 0, 0, 0, 0, start.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(start.Day(), lastDay)-1)
+}
+
+func listRecurring(db database) error {
+	recurring, err := recurringTransactions(db)
+	if err != nil {
+		return err
+	}
+	if len(recurring) == 0 {
+		fmt.Println("No recurring transactions yet.")
+		return nil
+	}
+
+	out := table{headers: []string{"ID", "Cost", "Category", "Cadence", "Since", "Last applied", "Comment"}}
+	for _, r := range recurring {
+		out.rows = append(out.rows, []string{
+			strconv.Itoa(r.id), r.cost.String(), categoryOrNA(r.category.String), r.cadence, r.startDate, r.lastApplied.String, r.comment,
+		})
+	}
+	out.print()
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_occurrence(t *testing.T) {
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cadence string
+		n       int
+		want    string
+	}{
+		{"daily", 1, "2024-02-01"},
+		{"weekly", 2, "2024-02-14"},
+		{"monthly", 0, "2024-01-31"},
+		{"monthly", 1, "2024-02-29"},
+		{"monthly", 2, "2024-03-31"},
+		{"monthly", 3, "2024-04-30"},
+		{"yearly", 1, "2025-01-31"},
+	}
+	for _, tt := range tests {
+		if got := occurrence(start, tt.cadence, tt.n).Format("2006-01-02"); got != tt.want {
+			t.Errorf("occurrence(%s, %d) = %s, want %s", tt.cadence, tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_applyRecurring(t *testing.T) {
+	db := newTestDB(t)
+	if err := addRecurring(db, "850 rent monthly", "", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+
+	upTo := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	for range 2 { // applying again must not insert the same occurrences twice
+		if err := applyRecurring(db, upTo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := db.Query("SELECT date FROM transactions WHERE category = 'rent' ORDER BY date")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			t.Fatal(err)
+		}
+		dates = append(dates, date)
+	}
+	want := []string{"2024-01-31", "2024-02-29", "2024-03-31"}
+	if len(dates) != len(want) {
+		t.Fatalf("got rent on %v, want %v", dates, want)
+	}
+	for i := range want {
+		if dates[i] != want[i] {
+			t.Errorf("got rent on %v, want %v", dates, want)
+		}
+	}
+}
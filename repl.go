@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// repl adds the transactions read line by line from in until exit, keeping a running total of the session. The dates
+// and categories are checked as on the command line, unless forced, with the confirmations read from in too. The
+// session ends as well once ctx is done, e.g. on a signal.
+func repl(ctx context.Context, db database, c userConfig, in io.Reader, force bool) error {
+	o := statsOptions{currency: c.currency, numbers: c.numbers}
+	fmt.Println("Add transactions as: <cost> [<category>] [-c <comment>] [-d <date>] [-income], type exit when done.")
+	scanner := bufio.NewScanner(in)
+	lines := make(chan string)
+	go func() { // so that waiting for a line does not keep the session from ending with ctx
+		defer close(lines)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	next := func() (string, bool) {
+		select {
+		case <-ctx.Done():
+			return "", false
+		case line, ok := <-lines:
+			return line, ok
+		}
+	}
+	confirm := func(question string) bool {
+		fmt.Print(question)
+		line, ok := next()
+		return ok && strings.TrimSpace(line) == "yes"
+	}
+	var total cents
+	for {
+		fmt.Print("liet> ")
+		line, ok := next()
+		if !ok {
+			fmt.Println()
+			break
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			break
+		}
+
+		a, f, err := parseReplLine(line, time.Now())
+		if err != nil { // a typo should not end the session, nor is the whole session messed up
+			fmt.Println(strings.TrimPrefix(err.Error(), errUser.Error()+": "))
+			continue
+		}
+		if !force && !f.force {
+			a.category, err = confirmCategory(db, a.category, confirm)
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil { // interrupted while asking
+				continue
+			}
+		}
+		cost, err := recordTransaction(db, a, f, c)
+		if err != nil {
+			return err
+		}
+		total += cost
+		fmt.Printf("Session total: %s\n", o.formatCost(total))
+	}
+	if ctx.Err() != nil {
+		fmt.Printf("Session interrupted, total of %s.\n", o.formatCost(total))
+		return nil
+	}
+	if err := scanner.Err(); err != nil { // the lines are closed once the scanner is done
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	fmt.Printf("Session done, total of %s.\n", o.formatCost(total))
+	return nil
+}
+
+// parseReplLine parses a repl line the way parse does the command line, but with an error instead of exiting.
+func parseReplLine(line string, now time.Time) (arguments, flags, error) {
+	a, f := arguments{}, flags{}
+	words, err := splitWords(line)
+	if err != nil {
+		return a, f, err
+	}
+	flagset := flag.NewFlagSet("liet", flag.ContinueOnError)
+	flagset.SetOutput(io.Discard)
+	flagset.StringVar(&f.comment, "c", "", "")
+	flagset.StringVar(&f.date, "d", "", "")
+	flagset.BoolVar(&f.income, "income", false, "")
+	flagset.BoolVar(&f.force, "force", false, "")
+	args, err := parseInterspersed(flagset, words)
+	if err != nil {
+		return a, f, fmt.Errorf("%w: %w, expecting <cost> [<category>] [-c <comment>] [-d <date>] [-income]", errUser, err)
+	}
+	if len(args) == 0 || len(args) > 2 { //nolint:mnd // cost and category
+		return a, f, fmt.Errorf("%w: expecting <cost> [<category>] [-c <comment>] [-d <date>] [-income]", errUser)
+	}
+
+	a.cost, err = parseAmount(args[0])
+	if err != nil {
+		return a, f, fmt.Errorf("%w: invalid cost value %q, expecting a number", errUser, args[0])
+	}
+	a.costSet = true
+	if len(args) > 1 {
+		a.category = args[1]
+	}
+	f.date = resolveDate(f.date, now)
+	if f.date == "" {
+		f.date = now.Format("2006-01-02")
+	}
+	if _, _, err := parseDateTime(f.date); err != nil {
+		return a, f, err
+	}
+	if err := checkFutureDate(f.date, now); err != nil && !f.force {
+		return a, f, fmt.Errorf("%w, add -force to record it anyway", err)
+	}
+	return a, f, nil
+}
+
+// splitWords splits the line on spaces like a shell would, keeping what is between quotes together, e.g.
+// -c 'lunch out' is the words -c and lunch out.
+func splitWords(line string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: unterminated quote in %q", errUser, line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_splitWords(t *testing.T) {
+	got, err := splitWords(`10.50 "eating out" -c 'lunch with \"friends\"' -income`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.50", "eating out", "-c", `lunch with \"friends\"`, "-income"}
+	if !slices.Equal(got, want) {
+		t.Errorf("splitWords() = %q, want %q", got, want)
+	}
+	if _, err := splitWords(`10 -c 'unterminated`); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func Test_repl(t *testing.T) {
+	db := newTestDB(t)
+	in := strings.NewReader("10.50 groceries -c 'lunch out'\nnot a cost\n-income 5 refund -d 2023-10-01\nexit\n20 ignored\n")
+	if err := repl(context.Background(), db, userConfig{}, in, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	var total cents
+	if err := db.QueryRow("SELECT COUNT(*), SUM(cost) FROM transactions").Scan(&count, &total); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || total != 550 {
+		t.Errorf("got %d transactions totalling %v, want 2 totalling 5.50", count, total)
+	}
+
+	// a future date is rejected unless forced, a category close to an existing one is confirmed
+	in = strings.NewReader("3 food -d 2203-10-01\n4 grocerys\nyes\n5 grocerys -force\n6 food -d 2203-10-01 -force\n")
+	if err := repl(context.Background(), db, userConfig{}, in, false); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT category, date FROM transactions WHERE id > 2 ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var category, date string
+		if err := rows.Scan(&category, &date); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, category+" "+date)
+	}
+	today := time.Now().Format("2006-01-02")
+	if want := []string{"groceries " + today, "grocerys " + today, "food 2203-10-01"}; !slices.Equal(got, want) {
+		t.Errorf("got transactions %q, want %q", got, want)
+	}
+
+	// a signal ends the session while waiting for a line
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	waiting, _ := io.Pipe()
+	if err := repl(ctx, db, userConfig{}, waiting, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := parseReplLine("1 food -d 2203-10-01", time.Now()); !errors.Is(err, errUser) {
+		t.Errorf("expected a user error for a future date, got %v", err)
+	}
+}
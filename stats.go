@@ -1,12 +1,19 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
+	"encoding/csv"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
+	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // magic numbers.
@@ -15,240 +22,1609 @@ const (
 	daysOfMonth = 31 // yes, there is also 28, 29 and 30. but we don't care about that here.
 
 	costColWidth = 20
-	colPadding   = 2    // for padding column headers
-	highCost     = 1e15 // arbitrary high cost for pretty printing
+	colPadding   = 2  // for padding column headers
+	barWidth     = 40 // for the bar chart when the terminal width is unknown
+
+	growthThreshold = 20 // percentage of growth over the previous period that gets highlighted
 )
 
 type (
-	statsFunc    func(db database) error
+	statsFunc    func(db database, o statsOptions) error
 	statsCommand string
 )
 
-func statsHelp(statsMap map[statsCommand]statsFunc) {
-	helperMapping := map[statsCommand][2]string{
-		"alltime":   {"all-time", "Category-wise cost aggregation for all time"}, //nolint:misspell // this is a sanitized string
-		"lastweek":  {"last week", "Category-wise cost aggregation for the last week"},
-		"lastmonth": {"last month", "Category-wise cost aggregation for the last month"},
-		"today":     {"today", "Category-wise cost aggregation for today"},
+// statsOptions are the user preferences that shape the stats views.
+type statsOptions struct {
+	weekStart time.Weekday
+	budgets   map[string]cents
+	currency  string
+	numbers   numberFormat
+	renderer  tableRenderer // defaults to textRenderer
+	sort      string        // of the category rows, one of sortOrders, defaults to cost-desc
+	minShare  float64       // percentage of the expenses under which categories are folded into Other
+	counts    bool          // shows the number of transactions next to the costs of the monthly and weekly stats
+
+	reportCurrency string  // of the second cost column of the category stats
+	reportRate     float64 // of the report currency to the currency, no second cost column when 0
+	dateFormat     string  // Go layout of the dates shown, see displayDate
+}
+
+// render prints the table with the configured renderer.
+func (o statsOptions) render(t table) {
+	if o.renderer == nil {
+		o.renderer = textRenderer{}
+	}
+	o.renderer.render(t)
+}
+
+// formatCost formats a cost for display, with the currency symbol if one is configured.
+func (o statsOptions) formatCost(c cents) string {
+	if c < 0 {
+		return "-" + o.currency + o.numbers.format(-c)
 	}
+	return o.currency + o.numbers.format(c)
+}
+
+// formatReportCost formats a cost converted to the report currency at the report rate.
+func (o statsOptions) formatReportCost(c cents) string {
+	o.currency = o.reportCurrency
+	return o.formatCost(cents(math.Round(float64(c) * o.reportRate)))
+}
+
+// statsDescriptions are the spoken form and the description of the named stats views.
+var statsDescriptions = map[statsCommand][2]string{
+	"alltime":    {"all-time", "Category-wise cost aggregation for all time"}, //nolint:misspell // this is a sanitized string
+	"lastweek":   {"last week", "Category-wise cost aggregation for the last week"},
+	"lastmonth":  {"last month", "Category-wise cost aggregation for the last month"},
+	"today":      {"today", "Category-wise cost aggregation for today"},
+	"yesterday":  {"yesterday", "Category-wise cost aggregation for yesterday"},
+	"budgets":    {"budgets", "Spending of this month against the configured category budgets"},
+	"chart":      {"chart", "Bar chart of the all time cost of each category"},
+	"monthly":    {"monthly", "Category-wise cost of each of the last 12 months"},
+	"weekly":     {"weekly", "Category-wise cost of each week of this quarter"},
+	"thisyear":   {"this year", "Category-wise cost aggregation for this year"},
+	"lastyear":   {"last year", "Category-wise cost aggregation for the last year"},
+	"compare":    {"compare", "Category-wise cost of this month so far against the same days of last month"},
+	"weekday":    {"weekday", "All time cost of each day of the week and its average per day with spending"},
+	"biggest":    {"biggest", "The single highest cost transaction of this and last week, month, this year and all time"},
+	"trailing12": {"trailing 12", "Category-wise cost aggregation for the last 12 months up to today, across the calendar years"},
+	"forecast":   {"forecast", "Rough projection of this month's spending per category to the end of the month"},
+	"outliers":   {"outliers", "The expenses of the last 90 days more than 2 standard deviations above their mean cost"},
+}
+
+// statsPatterns are the stats views given by a pattern instead of a name, with an example and their description.
+var statsPatterns = [][3]string{
+	{"YYYY-MM-DD:YYYY-MM-DD", "", "Category-wise cost aggregation for a custom date range, both ends included"},
+	{"tag:name", "tag:vacation", "Category-wise cost aggregation of the transactions tagged with #name in their comment"},
+	{"topN", "top5", "The N categories with the highest all time cost, the rest summed as Other"},
+	{"trailingN", "trailing 6", "Category-wise cost aggregation for the last N months up to today"},
+}
 
+func statsHelp(statsMap map[statsCommand]statsFunc) {
 	fmt.Println("Valid stats commands:")
 	for cmd := range statsMap {
-		h, exists := helperMapping[cmd]
+		h, exists := statsDescriptions[cmd]
 		if !exists {
 			fmt.Printf("- %s (no description available)\n", cmd)
 			continue
 		}
 		fmt.Printf("- '%s' or '%s': %s\n", cmd, h[0], h[1])
 	}
+	for _, p := range statsPatterns {
+		if p[1] == "" {
+			fmt.Printf("- '%s': %s\n", p[0], p[2])
+			continue
+		}
+		fmt.Printf("- '%s', e.g. '%s': %s\n", p[0], p[1], p[2])
+	}
 }
 
-func statsRunner(db database, stats string) error {
-	statsMap := map[statsCommand]statsFunc{
+// statsCommands returns the named stats views, the single source of truth for the -w values.
+func statsCommands() map[statsCommand]statsFunc {
+	return map[statsCommand]statsFunc{
 		"alltime":   allTimeCostAggregation, //nolint:misspell // this is a sanitized string
 		"today":     todayCostAggregation,
+		"yesterday": yesterdayCostAggregation,
 		"week":      thisWeekCostAggregation,
 		"month":     thisMonthCostAggregation,
 		"lastweek":  lastWeekCostAggregation,
 		"lastmonth": lastMonthCostAggregation,
 		"monthly":   monthlyCostAggregation,
+		"weekly":    weeklyCostAggregation,
+		"budgets":   budgetsTable,
+		"chart":     costBarChart,
+		"thisyear":  thisYearCostAggregation,
+		"lastyear":  lastYearCostAggregation,
+		"biggest":   biggestTransactions,
+		"weekday":   weekdayCostAggregation,
+		"compare":   compareCostAggregation,
+		"outliers":  outlierTransactions,
+		"forecast":  forecastCostAggregation,
+		"trailing12": func(db database, o statsOptions) error {
+			return trailingCostAggregation(db, o, 12) //nolint:mnd // a year of months
+		},
+	}
+}
+
+// statsRunner prints the stats view, this month when none is given, e.g. by a bare -w.
+func statsRunner(db database, stats string, o statsOptions) error {
+	statsMap := statsCommands()
+	stats = cmp.Or(strings.TrimSpace(stats), defaultStatsWindow)
+
+	if tag, ok := strings.CutPrefix(strings.TrimSpace(stats), "tag:"); ok {
+		return tagCostAggregation(db, o, tag)
+	}
+	if start, end, ok := strings.Cut(strings.TrimSpace(stats), ":"); ok {
+		err := validateDateRange(start, end)
+		if err != nil {
+			return err
+		}
+		return costAggregrationTable(db, o, "custom range", start, end)
 	}
 
-	s := statsCommand(strings.TrimSpace(strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(stats, "-", ""), " ", ""))))
+	s := statsCommandName(stats)
 	if s == "help" || s == "-h" || s == "--help" {
 		statsHelp(statsMap)
 		return nil
 	}
 	if statsFunc, ok := statsMap[s]; ok {
-		return statsFunc(db)
+		return statsFunc(db, o)
+	}
+	if n, ok := strings.CutPrefix(string(s), "top"); ok {
+		top, err := strconv.Atoi(n)
+		if err != nil || top <= 0 {
+			return fmt.Errorf("%w: invalid number of categories %q, expecting e.g. top5", errUser, n)
+		}
+		return topCostAggregation(db, o, top)
+	}
+	if n, ok := strings.CutPrefix(string(s), "trailing"); ok {
+		months, err := strconv.Atoi(n)
+		if err != nil || months <= 0 {
+			return fmt.Errorf("%w: invalid number of months %q, expecting e.g. trailing6", errUser, n)
+		}
+		return trailingCostAggregation(db, o, months)
 	}
 	fmt.Printf("Unknown stats command: %s, run with -w help to know valid values\n", stats)
 	return nil
 }
 
-func allTimeCostAggregation(db database) error {
-	return costAggregrationTable(db, "all time", "0000-00-00", "9999-12-31")
+// statsCommandName sanitizes the name of a stats view, e.g. "Last Month" is lastmonth.
+func statsCommandName(stats string) statsCommand {
+	return statsCommand(strings.TrimSpace(strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(stats, "-", ""), " ", ""))))
+}
+
+// knownStats tells whether statsRunner has the stats view, e.g. to check the default_stats config.
+func knownStats(stats string) bool {
+	if strings.HasPrefix(strings.TrimSpace(stats), "tag:") {
+		return true
+	}
+	if start, end, ok := strings.Cut(strings.TrimSpace(stats), ":"); ok {
+		return validateDateRange(start, end) == nil
+	}
+	s := statsCommandName(stats)
+	if _, ok := statsCommands()[s]; ok {
+		return true
+	}
+	for _, prefix := range []string{"top", "trailing"} {
+		if n, ok := strings.CutPrefix(string(s), prefix); ok {
+			count, err := strconv.Atoi(n)
+			return err == nil && count > 0
+		}
+	}
+	return false
+}
+
+func validateDateRange(start, end string) error {
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return fmt.Errorf("%w: invalid start date %q in range, expecting YYYY-MM-DD:YYYY-MM-DD", errUser, start)
+	}
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return fmt.Errorf("%w: invalid end date %q in range, expecting YYYY-MM-DD:YYYY-MM-DD", errUser, end)
+	}
+	if endDate.Before(startDate) {
+		return fmt.Errorf("%w: range end %s is before its start %s", errUser, end, start)
+	}
+	return nil
+}
+
+func allTimeCostAggregation(db database, o statsOptions) error {
+	return costAggregrationTable(db, o, "all time", "0000-00-00", "9999-12-31")
 }
 
-func todayCostAggregation(db database) error {
+func todayCostAggregation(db database, o statsOptions) error {
 	now := time.Now()
 	startDate := now.Format("2006-01-02")
 	endDate := now.AddDate(0, 0, 1).Format("2006-01-02")
 	slog.Debug("Today is", "startDate", startDate, "endDate", endDate)
-	return costAggregrationTable(db, "today", startDate, endDate)
+	return costAggregrationTable(db, o, "today", startDate, endDate)
 }
 
-func thisWeekCostAggregation(db database) error {
-	now := time.Now()
-	startDate := now.AddDate(0, 0, -int(now.Weekday()-1)).Format("2006-01-02")
-	endDate := now.AddDate(0, 0, daysOfWeek-int(now.Weekday())).Format("2006-01-02")
+func yesterdayCostAggregation(db database, o statsOptions) error {
+	// the date range is inclusive, so yesterday both starts and ends yesterday
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	slog.Debug("Yesterday is", "startDate", yesterday, "endDate", yesterday)
+	return costAggregrationTable(db, o, "yesterday", yesterday, yesterday)
+}
+
+func thisWeekCostAggregation(db database, o statsOptions) error {
+	startDate, endDate := weekRange(time.Now(), o.weekStart, 0)
 	slog.Debug("This week is", "startDate", startDate, "endDate", endDate)
-	return costAggregrationTable(db, "this week", startDate, endDate)
+	return costAggregrationTable(db, o, "this week", startDate, endDate)
 }
 
-func thisMonthCostAggregation(db database) error {
-	now := time.Now()
+// weekRange returns the first and last day of the week starting on weekStart that contains t, shifted back by weeksAgo weeks.
+func weekRange(t time.Time, weekStart time.Weekday, weeksAgo int) (string, string) {
+	daysSinceStart := (int(t.Weekday()) - int(weekStart) + daysOfWeek) % daysOfWeek
+	start := t.AddDate(0, 0, -daysSinceStart-weeksAgo*daysOfWeek)
+	end := start.AddDate(0, 0, daysOfWeek-1)
+	return start.Format("2006-01-02"), end.Format("2006-01-02")
+}
+
+func thisMonthCostAggregation(db database, o statsOptions) error {
+	startDate, endDate := thisMonthRange(time.Now())
+	slog.Debug("This month is", "startDate", startDate, "endDate", endDate)
+	return costAggregrationTable(db, o, "this month", startDate, endDate)
+}
+
+func thisMonthRange(now time.Time) (string, string) {
 	startDate := now.AddDate(0, 0, -now.Day()+1).Format("2006-01-02")
 	// does not really matter we use 31, we don't expect to have transactions in the future
 	endDate := now.AddDate(0, 1, daysOfMonth-now.Day()).Format("2006-01-02")
-	slog.Debug("This month is", "startDate", startDate, "endDate", endDate)
-	return costAggregrationTable(db, "this month", startDate, endDate)
+	return startDate, endDate
 }
 
-func lastWeekCostAggregation(db database) error {
+// forecastCostAggregation projects the spending of this month so far to the end of the month, assuming the same
+// daily spending for the days left.
+func forecastCostAggregation(db database, o statsOptions) error {
 	now := time.Now()
-	startDate := now.AddDate(0, 0, -int(now.Weekday()-1)-daysOfWeek).Format("2006-01-02")
-	endDate := now.AddDate(0, 0, -int(now.Weekday())).Format("2006-01-02")
+	startDate, endDate := thisMonthRange(now)
+	summaries, err := costAggregration(db, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+	elapsed, err := windowDays(db, startDate, endDate, now)
+	if err != nil {
+		return err
+	}
+	summaries = slices.DeleteFunc(summaries, func(s transactionSummary) bool { return s.expenses() <= 0 })
+	if len(summaries) == 0 || elapsed == 0 {
+		fmt.Println("No transactions found for this month.")
+		return nil
+	}
+	days := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()).Day() // the last day of this month
+
+	headers := []string{"Category", fmt.Sprintf("Day %d of %d", elapsed, days), "Projected (rough)"}
+	if len(o.budgets) > 0 {
+		headers = append(headers, "Budget")
+	}
+	t := table{headers: headers, minWidth: costColWidth - 1}
+	var spent cents
+	for _, s := range summaries {
+		projected := project(s.expenses(), elapsed, days)
+		row := []string{s.categoryName(), o.formatCost(s.expenses()), o.formatCost(projected)}
+		color := ""
+		if len(o.budgets) > 0 {
+			limit, ok := o.budgets[s.category.String]
+			row = append(row, "")
+			if ok && s.category.Valid {
+				row[len(row)-1] = o.formatCost(limit)
+				if projected > limit {
+					color = colorRed
+				}
+			}
+		}
+		t.rows = append(t.rows, row)
+		t.rowColors = append(t.rowColors, color)
+		spent += s.expenses()
+	}
+	total := []string{"Total", o.formatCost(spent), o.formatCost(project(spent, elapsed, days))}
+	if len(o.budgets) > 0 {
+		total = append(total, "")
+	}
+	t.footer = [][]string{total}
+	o.render(t)
+	return nil
+}
+
+// project extrapolates the spending of the elapsed days to all the days, at the same daily rate.
+func project(spent cents, elapsed, days int) cents {
+	if elapsed <= 0 {
+		return spent
+	}
+	return cents(math.Round(float64(spent) * float64(days) / float64(elapsed)))
+}
+
+func lastWeekCostAggregation(db database, o statsOptions) error {
+	startDate, endDate := weekRange(time.Now(), o.weekStart, 1)
 	slog.Debug("Last week is", "startDate", startDate, "endDate", endDate)
-	return costAggregrationTable(db, "last week", startDate, endDate)
+	return costAggregrationTable(db, o, "last week", startDate, endDate)
 }
 
-func lastMonthCostAggregation(db database) error {
+func lastMonthCostAggregation(db database, o statsOptions) error {
 	now := time.Now()
 	startDate := now.AddDate(0, -1, -now.Day()+1).Format("2006-01-02")
 	endDate := now.AddDate(0, 0, -now.Day()).Format("2006-01-02")
 	slog.Debug("Last month is", "startDate", startDate, "endDate", endDate)
-	return costAggregrationTable(db, "last month", startDate, endDate)
+	return costAggregrationTable(db, o, "last month", startDate, endDate)
 }
 
-func costAggregrationTable(db database, queryType, startDate, endDate string) error {
-	allTimeSummaries, err := costAggregration(db, startDate, endDate)
-	if err != nil {
-		return fmt.Errorf("failed to aggregate costs: %w", err)
-	}
+func thisYearCostAggregation(db database, o statsOptions) error {
+	startDate, endDate := yearRange(time.Now().Year())
+	slog.Debug("This year is", "startDate", startDate, "endDate", endDate)
+	return costAggregrationTable(db, o, "this year", startDate, endDate)
+}
 
-	if len(allTimeSummaries) == 0 {
-		fmt.Printf("No transactions found for %s.\n", queryType)
-		return nil
+func lastYearCostAggregation(db database, o statsOptions) error {
+	startDate, endDate := yearRange(time.Now().Year() - 1)
+	slog.Debug("Last year is", "startDate", startDate, "endDate", endDate)
+	return costAggregrationTable(db, o, "last year", startDate, endDate)
+}
+
+// trailingCostAggregation shows the cost of the last months up to today, ignoring the calendar boundaries, e.g. the
+// trailing 12 months are the last 365 days.
+func trailingCostAggregation(db database, o statsOptions, months int) error {
+	now := time.Now()
+	startDate := now.AddDate(0, -months, 1).Format("2006-01-02")
+	endDate := now.Format("2006-01-02")
+	slog.Debug("Trailing months are", "months", months, "startDate", startDate, "endDate", endDate)
+	return costAggregrationTable(db, o, fmt.Sprintf("the trailing %d months", months), startDate, endDate)
+}
+
+// biggestTransactions shows the single highest cost transaction of each window, the one skewing its totals.
+func biggestTransactions(db database, o statsOptions) error {
+	now := time.Now()
+	thisWeekStart, thisWeekEnd := weekRange(now, o.weekStart, 0)
+	lastWeekStart, lastWeekEnd := weekRange(now, o.weekStart, 1)
+	thisMonthStart, thisMonthEnd := monthRange(now)
+	lastMonthStart, lastMonthEnd := monthRange(time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location()))
+	thisYearStart, thisYearEnd := yearRange(now.Year())
+	windows := []struct{ name, start, end string }{
+		{"This week", thisWeekStart, thisWeekEnd},
+		{"Last week", lastWeekStart, lastWeekEnd},
+		{"This month", thisMonthStart, thisMonthEnd},
+		{"Last month", lastMonthStart, lastMonthEnd},
+		{"This year", thisYearStart, thisYearEnd},
+		{"All time", "0000-00-00", "9999-12-31"},
 	}
 
-	maxLen := len(slices.MaxFunc(allTimeSummaries, func(a, b transactionSummary) int {
-		return len(a.category.String) - len(b.category.String)
-	}).category.String)
-	if maxLen < len("Category")+2 {
-		maxLen = len("Category") + colPadding
+	t := table{headers: []string{"Window", "Date", "Cost", "Category", "Comment"}}
+	for _, w := range windows {
+		biggest, ok, err := biggestTransaction(db, w.start, w.end)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			t.rows = append(t.rows, []string{w.name, "", "", "", ""})
+			t.rowColors = append(t.rowColors, colorDim)
+			continue
+		}
+		category := "N/A"
+		if biggest.category.Valid {
+			category = biggest.category.String
+		}
+		t.rows = append(t.rows, []string{w.name, displayDate(biggest.date, o.dateFormat), o.formatCost(biggest.cost), category, biggest.comment})
+		t.rowColors = append(t.rowColors, "")
 	}
+	o.render(t)
+	return nil
+}
 
-	line := strings.Repeat("-", maxLen+3+costColWidth)
-	fmt.Printf(`
-%v
-|%*s |%19s |
-%v
-`, line, maxLen-1, "Category", "Cost", line)
+// biggestTransaction returns the highest cost transaction between the dates, if there is any expense.
+func biggestTransaction(db database, startDate, endDate string) (transaction, bool, error) {
+	t := transaction{}
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return t, false, err
+	}
+	rows, err := db.Query(`
+SELECT
+    id, cost, category, COALESCE(comment, ''), date
+FROM
+    `+from+`
+WHERE
+    date BETWEEN ? AND ? AND cost > 0 AND currency IS NULL
+ORDER BY
+    cost DESC, id DESC
+LIMIT 1;
+	`, startDate, endDate)
+	if err != nil {
+		return t, false, fmt.Errorf("failed to query biggest transaction: %w", err)
+	}
+	defer handleErrClose(rows.Close)
 
-	slices.SortFunc(allTimeSummaries, func(a, b transactionSummary) int { return int(a.totalCost - b.totalCost) })
-	for _, s := range allTimeSummaries {
-		category := "N/A"
-		if s.category.Valid {
-			category = s.category.String
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return t, false, fmt.Errorf("error iterating over rows: %w", rows.Err())
 		}
-		if s.totalCost > highCost {
-			fmt.Printf("|%*s | %18.10g |\n", maxLen-1, category, s.totalCost)
-		} else {
-			fmt.Printf("|%*s | %18.2f |\n", maxLen-1, category, s.totalCost)
+		return t, false, nil
+	}
+	if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date); err != nil {
+		return t, false, fmt.Errorf("failed to scan row: %w", err)
+	}
+	return t, true, nil
+}
+
+// weekdayCostAggregation shows the all time expenses of each day of the week, from the configured week start.
+func weekdayCostAggregation(db database, o statsOptions) error {
+	from, err := transactionsIn(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`
+SELECT
+    CAST(strftime('%w', date) AS INTEGER) AS weekday,
+    SUM(cost) AS total_cost,
+    COUNT(DISTINCT date) AS days
+FROM
+    ` + from + `
+WHERE
+    cost > 0 AND currency IS NULL
+GROUP BY
+    weekday;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query weekday stats: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var (
+		totals [daysOfWeek]cents
+		days   [daysOfWeek]int
+	)
+	for rows.Next() {
+		var (
+			weekday, n int
+			total      cents
+		)
+		if err := rows.Scan(&weekday, &total, &n); err != nil {
+			return fmt.Errorf("error scanning weekday row: %w", err)
 		}
+		totals[weekday], days[weekday] = total, n
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
 	}
-	fmt.Println(line)
 
+	t := table{headers: []string{"Weekday", "Cost", "Average day"}, minWidth: costColWidth - 1}
+	for i := range daysOfWeek {
+		weekday := (int(o.weekStart) + i) % daysOfWeek
+		var avg cents
+		if days[weekday] > 0 {
+			avg = cents(math.Round(float64(totals[weekday]) / float64(days[weekday])))
+		}
+		t.rows = append(t.rows, []string{time.Weekday(weekday).String(), o.formatCost(totals[weekday]), o.formatCost(avg)})
+	}
+	o.render(t)
 	return nil
 }
 
-func monthlyCostAggregation(db database) error {
+// compareCostAggregation compares the cost of each category this month so far with the same days of last month.
+func compareCostAggregation(db database, o statsOptions) error {
 	now := time.Now()
-	expenses := make(map[string][]transactionSummary, 0)
-	for m := time.January; m <= now.Month(); m++ {
-		startDate := time.Date(now.Year(), m, 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02")
-		endDate := time.Date(now.Year(), m+1, 1, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -1).Format("2006-01-02")
-		slog.Debug("Month", "month", m.String(), "startDate", startDate, "endDate", endDate)
-		monthExpenses, err := costAggregration(db, startDate, endDate)
-		if err != nil {
-			return fmt.Errorf("failed to aggregate costs for month %s: %w", m.String(), err)
+	currentStart, _ := monthRange(now)
+	currentEnd := now.Format("2006-01-02")
+	previousMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location())
+	previousStart, previousMonthEnd := monthRange(previousMonth)
+	previousEnd := previousMonth.AddDate(0, 0, now.Day()-1).Format("2006-01-02")
+	if previousEnd > previousMonthEnd { // e.g. the 31st when last month had 30 days
+		previousEnd = previousMonthEnd
+	}
+	slog.Debug("Comparing", "currentStart", currentStart, "currentEnd", currentEnd, "previousStart", previousStart, "previousEnd", previousEnd)
+
+	current, err := costAggregration(db, currentStart, currentEnd)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs of this month: %w", err)
+	}
+	previous, err := costAggregration(db, previousStart, previousEnd)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs of last month: %w", err)
+	}
+	if len(current) == 0 && len(previous) == 0 {
+		fmt.Println("No transactions found for this month nor last month.")
+		return nil
+	}
+
+	currentCosts, previousCosts := map[string]cents{}, map[string]cents{}
+	for _, s := range current {
+		currentCosts[s.categoryName()] += s.totalCost
+	}
+	for _, s := range previous {
+		previousCosts[s.categoryName()] += s.totalCost
+	}
+	categories := slices.Sorted(maps.Keys(currentCosts))
+	for category := range previousCosts {
+		if _, ok := currentCosts[category]; !ok {
+			categories = append(categories, category)
 		}
-		expenses[m.String()] = monthExpenses
 	}
-	uniqueCategories := map[string]struct{}{}
-	for _, monthExpenses := range expenses {
-		for _, s := range monthExpenses {
-			if s.category.Valid {
-				uniqueCategories[s.category.String] = struct{}{}
-			} else {
-				uniqueCategories["N/A"] = struct{}{}
-			}
+	slices.Sort(categories)
+
+	t := table{headers: []string{"Category", "This month", "Last month", "Change", "Change %"}, minWidth: costColWidth - 1}
+	var currentTotal, previousTotal cents
+	for _, category := range categories {
+		c, p := currentCosts[category], previousCosts[category]
+		currentTotal += c
+		previousTotal += p
+		t.rows = append(t.rows, []string{category, o.formatCost(c), o.formatCost(p), o.formatCost(c - p), growth(c, p)})
+		color := ""
+		if c > 0 && (p <= 0 || float64(c-p) > float64(p)*growthThreshold/100) {
+			color = colorRed
 		}
+		t.rowColors = append(t.rowColors, color)
 	}
-	maxLen := len("Category") + colPadding
-	line := strings.Repeat("-", maxLen+2+(costColWidth+1)*len(expenses))
-	costLine := strings.Builder{}
-	for m := time.January; m <= now.Month(); m++ {
-		costLine.WriteString(fmt.Sprintf("%19s |", m.String()))
-	}
-	fmt.Printf(`
-%v
-|%*s |%s
-%v
-`, line, maxLen-1, "Category", costLine.String(), line)
-
-	for category := range uniqueCategories {
-		costLine.Reset()
-		for m := time.January; m <= now.Month(); m++ {
-			monthExpenses := expenses[m.String()]
-			totalCost := 0.0
-			for _, s := range monthExpenses {
-				if s.category.Valid && s.category.String == category {
-					totalCost += s.totalCost
-				} else if !s.category.Valid && category == "N/A" {
-					totalCost += s.totalCost
-				}
-			}
-			if totalCost > highCost { // completely arbitrary, for the pretty print
-				costLine.WriteString(fmt.Sprintf(" %18.10g |", totalCost))
-			} else {
-				costLine.WriteString(fmt.Sprintf(" %18.2f |", totalCost))
-			}
+	t.footer = [][]string{{
+		"Net", o.formatCost(currentTotal), o.formatCost(previousTotal),
+		o.formatCost(currentTotal - previousTotal), growth(currentTotal, previousTotal),
+	}}
+	o.render(t)
+	return nil
+}
+
+// growth formats the change from previous to current as a signed percentage, or "new" without a previous cost.
+func growth(current, previous cents) string {
+	if previous == 0 {
+		if current == 0 {
+			return ""
 		}
-		fmt.Printf("|%*s |%s\n", maxLen-1, category, costLine.String())
+		return "new"
 	}
+	return fmt.Sprintf("%+.1f%%", float64(current-previous)/math.Abs(float64(previous))*100) //nolint:mnd // percentage
+}
 
-	fmt.Println(line)
-	return nil
+func yearRange(year int) (string, string) {
+	return fmt.Sprintf("%04d-01-01", year), fmt.Sprintf("%04d-12-31", year)
 }
 
-type transactionSummary struct {
-	category  sql.NullString
-	totalCost float64
+func costAggregrationTable(db database, o statsOptions, queryType, startDate, endDate string) error {
+	allTimeSummaries, err := costAggregration(db, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+	costs, err := expenseCosts(db, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	days, err := windowDays(db, startDate, endDate, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := summariesTable(o, queryType, allTimeSummaries, costs, days); err != nil {
+		return err
+	}
+	return currenciesTable(db, o, startDate, endDate)
 }
 
-func costAggregration(db database, startDate, endDate string) ([]transactionSummary, error) {
+// currencyTotal is the sum of the transactions in a currency other than the base one.
+type currencyTotal struct {
+	currency           string
+	expenses, income   cents
+	transactionsNumber int
+}
+
+// currencyTotals sums the transactions in each currency other than the base one between the dates.
+func currencyTotals(db database, startDate, endDate string) ([]currencyTotal, error) {
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
 	rows, err := db.Query(`
 SELECT
-    category,
-    SUM(cost) AS total_cost
+    currency,
+    SUM(CASE WHEN cost > 0 THEN cost ELSE 0 END) AS expenses,
+    SUM(CASE WHEN cost < 0 THEN -cost ELSE 0 END) AS income,
+    COUNT(*) AS transactions
 FROM
-    transactions
+    `+from+`
 WHERE
-    date BETWEEN ? AND ?  -- Filter by date range
+    date BETWEEN ? AND ? AND currency IS NOT NULL
 GROUP BY
-    category
+    currency
 ORDER BY
-    category;
+    currency;
 	`, startDate, endDate)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query stats: %w", err)
+		return nil, fmt.Errorf("failed to query currency stats: %w", err)
 	}
 	defer handleErrClose(rows.Close)
 
-	var allTimeSummaries []transactionSummary
+	var totals []currencyTotal
 	for rows.Next() {
-		var s transactionSummary
-		if err := rows.Scan(&s.category, &s.totalCost); err != nil {
-			return nil, fmt.Errorf("error scanning all time row: %w", err)
+		var c currencyTotal
+		if err := rows.Scan(&c.currency, &c.expenses, &c.income, &c.transactionsNumber); err != nil {
+			return nil, fmt.Errorf("failed to scan currency row: %w", err)
 		}
-		allTimeSummaries = append(allTimeSummaries, s)
+		totals = append(totals, c)
 	}
-
 	if rows.Err() != nil {
 		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
 	}
+	return totals, nil
+}
 
-	return allTimeSummaries, nil
+// currenciesTable prints the expenses and income in each currency other than the base one between the dates, which
+// the category stats leave out rather than adding up amounts of different currencies.
+func currenciesTable(db database, o statsOptions, startDate, endDate string) error {
+	totals, err := currencyTotals(db, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	if _, ok := o.renderer.(csvRenderer); len(totals) == 0 || ok { // a single table in CSV
+		return nil
+	}
+
+	o.currency = "" // the code follows the amount instead
+	t := table{headers: []string{"Currency", "Expenses", "Income", "Transactions"}, minWidth: costColWidth - 1}
+	for _, c := range totals {
+		t.rows = append(t.rows, []string{
+			c.currency, o.formatCost(c.expenses) + " " + c.currency, o.formatCost(c.income) + " " + c.currency, strconv.Itoa(c.transactionsNumber),
+		})
+	}
+	fmt.Println("Not included above, in other currencies:")
+	o.render(t)
+	return nil
+}
+
+// windowDays counts the days of the window up to today, the all time window starts on the earliest transaction.
+func windowDays(db database, startDate, endDate string, now time.Time) (int, error) {
+	if startDate == "0000-00-00" {
+		from, err := transactionsIn(db, startDate, endDate)
+		if err != nil {
+			return 0, err
+		}
+		rows, err := db.Query("SELECT MIN(date) FROM " + from)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query earliest transaction: %w", err)
+		}
+		defer handleErrClose(rows.Close)
+		var earliest sql.NullString
+		if rows.Next() {
+			if err := rows.Scan(&earliest); err != nil {
+				return 0, fmt.Errorf("failed to scan earliest transaction: %w", err)
+			}
+		}
+		if rows.Err() != nil {
+			return 0, fmt.Errorf("error iterating over rows: %w", rows.Err())
+		}
+		startDate = earliest.String
+	}
+	endDate = min(endDate, now.Format("2006-01-02")) // the days to come have no spending yet
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return 0, nil //nolint:nilerr // no transactions or no valid start, so no days to average over
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil {
+		return 0, nil //nolint:nilerr // same as above
+	}
+	return max(0, int(end.Sub(start).Hours()/24)+1), nil //nolint:mnd // hours in a day
+}
+
+// summariesTable prints the cost of each category with its share of the expenses, followed by the totals, the
+// distribution of the individual expense costs, sorted ascending, and the expenses per day of the window.
+func summariesTable(o statsOptions, queryType string, allTimeSummaries []transactionSummary, costs []cents, days int) error {
+	if len(allTimeSummaries) == 0 {
+		fmt.Printf("No transactions found for %s.\n", queryType)
+		return nil
+	}
+
+	shares := newCategoryShares(allTimeSummaries, o)
+	allTimeSummaries, other, expenses, income := shares.summaries, shares.other, shares.expenses, shares.income
+	row := func(name string, c cents, share string) []string { // with the cost in the report currency, if any
+		if o.reportRate == 0 {
+			return []string{name, o.formatCost(c), share}
+		}
+		return []string{name, o.formatCost(c), o.formatReportCost(c), share}
+	}
+	t := table{headers: []string{"Category", "Cost", "Share"}, minWidth: costColWidth - 1}
+	if o.reportRate != 0 {
+		t.headers = slices.Insert(t.headers, 2, "Cost in "+cmp.Or(o.reportCurrency, "report currency"))
+	}
+	var highest transactionSummary
+	if len(allTimeSummaries) > 0 {
+		highest = slices.MaxFunc(allTimeSummaries, func(a, b transactionSummary) int { return cmp.Compare(a.totalCost, b.totalCost) })
+	}
+	for _, s := range allTimeSummaries {
+		t.rows = append(t.rows, row(s.categoryName(), s.totalCost, shares.share(s.totalCost)))
+		switch {
+		case !s.category.Valid:
+			t.rowColors = append(t.rowColors, colorDim)
+		case s == highest:
+			t.rowColors = append(t.rowColors, colorRed)
+		default:
+			t.rowColors = append(t.rowColors, "")
+		}
+	}
+	if other.count > 0 {
+		t.rows = append(t.rows, row("Other", other.totalCost, percentage(other.totalCost, expenses)))
+		t.rowColors = append(t.rowColors, colorDim)
+	}
+	if income != 0 {
+		t.footer = [][]string{
+			row("Expenses", expenses, percentage(expenses, expenses)),
+			row("Income", income, ""),
+			row("Net", expenses-income, ""),
+		}
+	} else {
+		t.footer = [][]string{row("Total", expenses, percentage(expenses, expenses))}
+	}
+	if len(costs) > 0 {
+		transactions := row("Transactions", 0, "")
+		transactions[1] = strconv.Itoa(len(costs))
+		if o.reportRate != 0 {
+			transactions[2] = ""
+		}
+		t.footer = append(t.footer, transactions, row("Average", average(costs), ""), row("Median", median(costs), ""))
+	}
+	if days > 0 && expenses > 0 {
+		daily := cents(math.Round(float64(expenses) / float64(days)))
+		t.footer = append(t.footer, row("Per day", daily, ""))
+	}
+	o.render(t)
+
+	return nil
+}
+
+// categoryShares are the category stats as shown: the summaries sorted, the small shares folded into other, and the
+// totals their shares are of. The text stats and the HTML report are both laid out from them.
+type categoryShares struct {
+	summaries        []transactionSummary
+	other            transactionSummary // of the folded categories, without transactions when none is
+	expenses, income cents
+}
+
+func newCategoryShares(summaries []transactionSummary, o statsOptions) categoryShares {
+	var c categoryShares
+	for _, s := range summaries {
+		c.expenses += s.expenses()
+		c.income += s.income
+	}
+	c.summaries, c.other = foldSmallShares(summaries, c.expenses, o.minShare)
+	sortSummaries(c.summaries, o.sort)
+	return c
+}
+
+// share returns the share of the expenses of a cost, empty for income which is not part of the spending share.
+func (c categoryShares) share(cost cents) string {
+	if cost <= 0 {
+		return ""
+	}
+	return percentage(cost, c.expenses)
+}
+
+// foldSmallShares takes the categories whose cost is under the minimum share of the expenses, in percent, out of the
+// summaries, returning the rest and the sum of the ones taken, which has no transactions when none is.
+func foldSmallShares(summaries []transactionSummary, expenses cents, minShare float64) ([]transactionSummary, transactionSummary) {
+	var other transactionSummary
+	if minShare <= 0 || expenses <= 0 {
+		return summaries, other
+	}
+	kept := summaries[:0]
+	for _, s := range summaries {
+		if s.totalCost > 0 && float64(s.totalCost)*100 < minShare*float64(expenses) { //nolint:mnd // percentages are out of 100
+			other.totalCost += s.totalCost
+			other.income += s.income
+			other.count += s.count
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, other
+}
+
+// sortOrders are the valid -sort orders of the category stats, the first is the default.
+var sortOrders = []string{"cost-desc", "cost-asc", "category", "count"}
+
+// sortSummaries sorts the summaries in one of the sortOrders, the ones that compare equal by category so the order is
+// always the same.
+func sortSummaries(summaries []transactionSummary, order string) {
+	slices.SortFunc(summaries, func(a, b transactionSummary) int {
+		byCategory := strings.Compare(a.categoryName(), b.categoryName())
+		switch order {
+		case "cost-asc":
+			return cmp.Or(cmp.Compare(a.totalCost, b.totalCost), byCategory)
+		case "category":
+			return byCategory
+		case "count":
+			return cmp.Or(cmp.Compare(b.count, a.count), cmp.Compare(b.totalCost, a.totalCost), byCategory)
+		default:
+			return cmp.Or(cmp.Compare(b.totalCost, a.totalCost), byCategory)
+		}
+	})
+}
+
+// expenseCosts returns the cost of each expense between the dates, sorted ascending.
+func expenseCosts(db database, startDate, endDate string) ([]cents, error) {
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(
+		"SELECT cost FROM "+from+" WHERE date BETWEEN ? AND ? AND cost > 0 AND currency IS NULL ORDER BY cost", startDate, endDate,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expenses: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+	return scanCosts(rows)
+}
+
+func scanCosts(rows *sql.Rows) ([]cents, error) {
+	var costs []cents
+	for rows.Next() {
+		var cost cents
+		if err := rows.Scan(&cost); err != nil {
+			return nil, fmt.Errorf("error scanning expense row: %w", err)
+		}
+		costs = append(costs, cost)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return costs, nil
+}
+
+// average of the costs, rounded to the nearest cent.
+func average(costs []cents) cents {
+	if len(costs) == 0 {
+		return 0
+	}
+	var total cents
+	for _, c := range costs {
+		total += c
+	}
+	return cents(math.Round(float64(total) / float64(len(costs))))
+}
+
+// median of the sorted costs, an even count averages the two middle costs.
+func median(sorted []cents) cents {
+	n := len(sorted)
+	switch {
+	case n == 0:
+		return 0
+	case n%2 == 1:
+		return sorted[n/2]
+	default:
+		return average(sorted[n/2-1 : n/2+1])
+	}
+}
+
+// meanStdDev returns the mean and the population standard deviation of the costs, in cents.
+func meanStdDev(costs []cents) (float64, float64) {
+	if len(costs) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, c := range costs {
+		sum += float64(c)
+	}
+	mean := sum / float64(len(costs))
+	var squares float64
+	for _, c := range costs {
+		squares += (float64(c) - mean) * (float64(c) - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(costs)))
+}
+
+const (
+	outlierDays   = 90
+	outlierStdDev = 2 // how many standard deviations above the mean make an outlier
+)
+
+// outlierTransactions shows the expenses of the last days whose cost is well above the mean, the unusual spending.
+func outlierTransactions(db database, o statsOptions) error {
+	now := time.Now()
+	startDate, endDate := now.AddDate(0, 0, -outlierDays+1).Format("2006-01-02"), now.Format("2006-01-02")
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`
+SELECT
+    id, cost, category, COALESCE(comment, ''), `+dateTime+`
+FROM
+    `+from+`
+WHERE
+    date BETWEEN ? AND ? AND cost > 0 AND currency IS NULL
+ORDER BY
+    cost DESC, id DESC;
+	`, startDate, endDate)
+	if err != nil {
+		return fmt.Errorf("failed to query expenses: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var (
+		expenses []transaction
+		costs    []cents
+	)
+	for rows.Next() {
+		var t transaction
+		if err := rows.Scan(&t.id, &t.cost, &t.category, &t.comment, &t.date); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		expenses = append(expenses, t)
+		costs = append(costs, t.cost)
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	if len(expenses) == 0 {
+		fmt.Printf("No transactions found for the last %d days.\n", outlierDays)
+		return nil
+	}
+
+	mean, stdDev := meanStdDev(costs)
+	t := table{headers: []string{"ID", "Date", "Cost", "Category", "Comment", "Above mean"}}
+	for _, e := range expenses {
+		if stdDev == 0 || float64(e.cost) <= mean+outlierStdDev*stdDev {
+			break // sorted by cost, the rest are not outliers either
+		}
+		category := "N/A"
+		if e.category.Valid {
+			category = e.category.String
+		}
+		t.rows = append(t.rows, []string{
+			strconv.Itoa(e.id), displayDate(e.date, o.dateFormat), o.formatCost(e.cost), category, e.comment,
+			fmt.Sprintf("%.1fσ", (float64(e.cost)-mean)/stdDev),
+		})
+		t.rowColors = append(t.rowColors, colorRed)
+	}
+	if len(t.rows) == 0 {
+		fmt.Printf("No outliers in the last %d days, every expense is within %d standard deviations of the mean.\n", outlierDays, outlierStdDev)
+		return nil
+	}
+	t.footer = [][]string{
+		{"Mean", "", o.formatCost(cents(math.Round(mean))), "", "", ""},
+		{"Std dev", "", o.formatCost(cents(math.Round(stdDev))), "", "", ""},
+	}
+	o.render(t)
+	return nil
+}
+
+func topCostAggregation(db database, o statsOptions, n int) error {
+	summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+
+	if len(summaries) == 0 {
+		fmt.Println("No transactions found for all time.")
+		return nil
+	}
+
+	// rank by spending only, income does not tell where the money is going
+	summaries = slices.DeleteFunc(summaries, func(s transactionSummary) bool { return s.expenses() <= 0 })
+	slices.SortFunc(summaries, func(a, b transactionSummary) int { return cmp.Compare(b.expenses(), a.expenses()) })
+	t := table{headers: []string{"Category", "Cost"}, minWidth: costColWidth - 1}
+	for _, s := range summaries[:min(n, len(summaries))] {
+		t.rows = append(t.rows, []string{s.categoryName(), o.formatCost(s.expenses())})
+	}
+	if len(summaries) > n {
+		var other cents
+		for _, s := range summaries[n:] {
+			other += s.expenses()
+		}
+		t.rows = append(t.rows, []string{"Other", o.formatCost(other)})
+	}
+	o.render(t)
+
+	return nil
+}
+
+func costBarChart(db database, o statsOptions) error {
+	summaries, err := costAggregration(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		return fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+	summaries = slices.DeleteFunc(summaries, func(s transactionSummary) bool { return s.expenses() <= 0 })
+	if len(summaries) == 0 {
+		fmt.Println("No transactions found for all time.")
+		return nil
+	}
+	slices.SortFunc(summaries, func(a, b transactionSummary) int { return cmp.Compare(b.expenses(), a.expenses()) })
+
+	var categoryWidth, costWidth int
+	for _, s := range summaries {
+		categoryWidth = max(categoryWidth, utf8.RuneCountInString(s.categoryName()))
+		costWidth = max(costWidth, utf8.RuneCountInString(o.formatCost(s.expenses())))
+	}
+	width := barWidth
+	if w := terminalWidth(); w > 0 {
+		width = max(1, w-categoryWidth-costWidth-len(" | ")-1)
+	}
+
+	highest := summaries[0].expenses()
+	fmt.Println()
+	for _, s := range summaries {
+		bar := max(1, int(int64(width)*int64(s.expenses())/int64(highest))) // even the smallest spending gets a bar
+		fmt.Printf("%*s | %-*s %*s\n", categoryWidth, s.categoryName(), width, strings.Repeat("#", bar), costWidth, o.formatCost(s.expenses()))
+	}
+	return nil
+}
+
+// terminalWidth returns the number of columns of the terminal, or 0 when it is unknown.
+func terminalWidth() int {
+	columns, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err == nil && columns > 0 {
+		return columns
+	}
+	return terminalColumns()
+}
+
+const monthsInWindow = 12
+
+// monthlyWindow returns the first day of each month of the rolling window ending in the month of now, oldest first.
+func monthlyWindow(now time.Time) []time.Time {
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	months := make([]time.Time, 0, monthsInWindow)
+	for i := monthsInWindow - 1; i >= 0; i-- {
+		months = append(months, current.AddDate(0, -i, 0))
+	}
+	return months
+}
+
+func monthlyCostAggregation(db database, o statsOptions) error {
+	layout := monthLayout(o.dateFormat)
+	var periods []matrixPeriod
+	for _, m := range monthlyWindow(time.Now()) {
+		start, end := monthRange(m)
+		periods = append(periods, matrixPeriod{header: m.Format(layout), start: start, end: end})
+	}
+	return costMatrix(db, o, periods)
+}
+
+// dayLayoutElements are the elements of a Go layout showing the day, the longest first.
+var dayLayoutElements = []string{"Monday", "Mon", "__2", "002", "_2", "02", "2"}
+
+// monthLayout returns the layout of the dates shown without their day, e.g. 01/2006 of 02/01/2006, to name the months
+// the way the dates are shown, or Jan 2006 when the layout has no month and year left.
+func monthLayout(dateLayout string) string {
+	const fallback = "Jan 2006"
+	if dateLayout == "" {
+		return fallback
+	}
+	layout := strings.ReplaceAll(dateLayout, "2006", "\x00") // the year has a 2 of its own
+	for _, element := range dayLayoutElements {
+		layout = strings.ReplaceAll(layout, element, "")
+	}
+	layout = strings.ReplaceAll(layout, "\x00", "2006")
+
+	var (
+		b         strings.Builder // the separators left around the day are merged into the last one
+		separator rune
+	)
+	for _, r := range layout {
+		if strings.ContainsRune(" /.,-", r) {
+			separator = r
+			continue
+		}
+		if separator != 0 && b.Len() > 0 {
+			b.WriteRune(separator)
+		}
+		separator = 0
+		b.WriteRune(r)
+	}
+	layout = b.String()
+	month := time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC)
+	if month.Format(layout) == month.AddDate(1, 0, 0).Format(layout) || month.Format(layout) == month.AddDate(0, 1, 0).Format(layout) {
+		return fallback
+	}
+	return layout
+}
+
+// weeklyWindow returns the weeks starting on weekStart of the quarter of now, from the one containing its first day up
+// to the one of now.
+func weeklyWindow(now time.Time, weekStart time.Weekday) []matrixPeriod {
+	quarterStart := time.Date(now.Year(), (now.Month()-1)/3*3+1, 1, 0, 0, 0, 0, now.Location()) //nolint:mnd // months in a quarter
+	today := now.Format("2006-01-02")
+	var weeks []matrixPeriod
+	for day := quarterStart; ; day = day.AddDate(0, 0, daysOfWeek) {
+		start, end := weekRange(day, weekStart, 0)
+		if start > today {
+			return weeks
+		}
+		first, _ := time.Parse("2006-01-02", start)
+		weeks = append(weeks, matrixPeriod{header: first.Format("Week of Jan 02"), start: start, end: end})
+	}
+}
+
+func weeklyCostAggregation(db database, o statsOptions) error {
+	weeks := weeklyWindow(time.Now(), o.weekStart)
+	if o.dateFormat != "" {
+		for i, w := range weeks {
+			weeks[i].header = "Week of " + displayDate(w.start, o.dateFormat)
+		}
+	}
+	return costMatrix(db, o, weeks)
+}
+
+// matrixPeriod is a column of a cost matrix, e.g. a month of -w monthly, from its start to its end date.
+type matrixPeriod struct {
+	header     string
+	start, end string
+}
+
+// costMatrix prints the cost of each category in each of the consecutive periods, with their net cost.
+func costMatrix(db database, o statsOptions, periods []matrixPeriod) error {
+	slog.Debug("Cost matrix window", "startDate", periods[0].start, "endDate", periods[len(periods)-1].end)
+	summaries, err := periodSummaries(db, periods)
+	if err != nil {
+		return err
+	}
+	uniqueCategories := map[string]struct{}{}
+	for _, periodSummaries := range summaries {
+		for category := range periodSummaries {
+			uniqueCategories[category] = struct{}{}
+		}
+	}
+
+	t := table{headers: []string{"Category"}, minWidth: costColWidth - 1}
+	for _, p := range periods {
+		t.headers = append(t.headers, p.header)
+	}
+	for _, category := range slices.Sorted(maps.Keys(uniqueCategories)) {
+		row := []string{category}
+		for i := range periods {
+			s := summaries[i][category]
+			cell := o.formatCost(s.totalCost)
+			if o.counts && s.count > 0 {
+				cell += fmt.Sprintf(" (%d)", s.count)
+			}
+			row = append(row, cell)
+		}
+		t.rows = append(t.rows, row)
+	}
+
+	net := []string{"Net"}
+	for i := range periods {
+		var totalCost cents
+		for _, s := range summaries[i] {
+			totalCost += s.totalCost
+		}
+		net = append(net, o.formatCost(totalCost))
+	}
+	t.footer = [][]string{net}
+	o.render(t)
+	return nil
+}
+
+// periodSummaries sums the costs and counts the transactions of the consecutive periods in a single scan, keyed by the
+// index of the period and then by category name.
+func periodSummaries(db database, periods []matrixPeriod) (map[int]map[string]transactionSummary, error) {
+	startDate, endDate := periods[0].start, periods[len(periods)-1].end
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+SELECT
+    date,
+    category,
+    SUM(cost) AS total_cost,
+    COUNT(*) AS transactions
+FROM
+    `+from+`
+WHERE
+    date BETWEEN ? AND ? AND currency IS NULL
+GROUP BY
+    date, category;
+	`, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query period stats: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	summaries := map[int]map[string]transactionSummary{}
+	for rows.Next() {
+		var (
+			date string
+			s    transactionSummary
+		)
+		if err := rows.Scan(&date, &s.category, &s.totalCost, &s.count); err != nil {
+			return nil, fmt.Errorf("error scanning period row: %w", err)
+		}
+		i, found := slices.BinarySearchFunc(periods, date, func(p matrixPeriod, date string) int {
+			switch {
+			case p.end < date:
+				return -1
+			case p.start > date:
+				return 1
+			default:
+				return 0
+			}
+		})
+		if !found {
+			continue
+		}
+		if summaries[i] == nil {
+			summaries[i] = map[string]transactionSummary{}
+		}
+		sum := summaries[i][s.categoryName()] // a category named N/A adds up with the ones without
+		sum.totalCost += s.totalCost
+		sum.count += s.count
+		summaries[i][s.categoryName()] = sum
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return summaries, nil
+}
+
+// monthRange returns the first and last day of the month containing t.
+func monthRange(t time.Time) (string, string) {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	end := start.AddDate(0, 1, -1)
+	return start.Format("2006-01-02"), end.Format("2006-01-02")
+}
+
+func monthCategoryCost(db database, category, startDate, endDate string) (cents, error) {
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Query(
+		"SELECT COALESCE(SUM(cost), 0) FROM "+from+" WHERE category = ? AND date BETWEEN ? AND ? AND currency IS NULL",
+		category, startDate, endDate,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query category cost: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+
+	var spent cents
+	if rows.Next() {
+		if err := rows.Scan(&spent); err != nil {
+			return 0, fmt.Errorf("failed to scan category cost: %w", err)
+		}
+	}
+	if rows.Err() != nil {
+		return 0, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+	return spent, nil
+}
+
+// budgetWarning nags the user when the month of the given date is over the budget of the category, if it has one.
+func budgetWarning(db database, budgets map[string]cents, category, date string) error {
+	limit, ok := budgets[category]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("%w: invalid date %q, expecting YYYY-MM-DD", errUser, date)
+	}
+	startDate, endDate := monthRange(t)
+	spent, err := monthCategoryCost(db, category, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	if spent > limit {
+		fmt.Printf("Warning: you are over your %s budget for %s, spent %v of %v\n", category, t.Format("January 2006"), spent, limit)
+	}
+	return nil
+}
+
+func budgetsTable(db database, o statsOptions) error {
+	if len(o.budgets) == 0 {
+		fmt.Println("No budgets configured, add a [budgets] section to your config file.")
+		return nil
+	}
+
+	startDate, endDate := monthRange(time.Now())
+	categories := slices.Sorted(maps.Keys(o.budgets))
+	rows := make([][]string, 0, len(categories))
+	for _, category := range categories {
+		spent, err := monthCategoryCost(db, category, startDate, endDate)
+		if err != nil {
+			return err
+		}
+		limit := o.budgets[category]
+		rows = append(rows, []string{category, o.formatCost(spent), o.formatCost(limit), o.formatCost(limit - spent)})
+	}
+	o.render(table{headers: []string{"Category", "Spent", "Limit", "Remaining"}, rows: rows})
+	return nil
+}
+
+// percentage formats part as a percentage of total.
+func percentage(part, total cents) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total)) //nolint:mnd // percentages are out of 100
+}
+
+// columnWidth returns the width needed to fit all values of a column, never narrower than its padded header.
+func columnWidth(header string, values ...string) int {
+	width := utf8.RuneCountInString(header) + colPadding
+	for _, v := range values {
+		width = max(width, utf8.RuneCountInString(v))
+	}
+	return width
+}
+
+// table holds the cells of a table apart from how it is printed.
+type table struct {
+	headers   []string
+	rows      [][]string
+	rowColors []string   // optional ANSI color of each row, see colorize
+	footer    [][]string // rows printed after a separator, e.g. totals
+	minWidth  int        // minimum width of every column but the first
+}
+
+// tableRenderer prints a table in some output format, see newRenderer.
+type tableRenderer interface {
+	render(t table)
+}
+
+// newRenderer returns the renderer of the -format value, text, md or csv.
+func newRenderer(format string) (tableRenderer, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return textRenderer{}, nil
+	case "md", "markdown":
+		return markdownRenderer{}, nil
+	case "csv":
+		return csvRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q, expecting text, md or csv", errUser, format)
+	}
+}
+
+// csvRenderer prints the table rows as CSV for spreadsheets, leaving out the footer since it can be derived.
+type csvRenderer struct{}
+
+func (csvRenderer) render(t table) {
+	w := csv.NewWriter(os.Stdout)
+	_ = w.Write(t.headers)
+	_ = w.WriteAll(t.rows)
+	if err := w.Error(); err != nil {
+		slog.Error("Failed to write CSV table", "error", err)
+	}
+}
+
+// textRenderer prints the table with borders, fitted to the terminal width.
+type textRenderer struct{}
+
+func (textRenderer) render(t table) { t.print() }
+
+// markdownRenderer prints the table as a GitHub-flavored Markdown table, the footer rows in bold.
+type markdownRenderer struct{}
+
+func (markdownRenderer) render(t table) {
+	escape := strings.NewReplacer("|", `\|`)
+	printRow := func(cells []string, bold bool) {
+		b := strings.Builder{}
+		b.WriteString("|")
+		for _, c := range cells {
+			c = escape.Replace(c)
+			if bold && c != "" {
+				c = "**" + c + "**"
+			}
+			b.WriteString(" " + c + " |")
+		}
+		fmt.Println(b.String())
+	}
+
+	fmt.Println()
+	printRow(t.headers, false)
+	alignment := make([]string, len(t.headers))
+	for i := range alignment {
+		alignment[i] = "---:" // numbers read better right aligned
+	}
+	alignment[0] = "---"
+	printRow(alignment, false)
+	for _, r := range t.rows {
+		printRow(r, false)
+	}
+	for _, r := range t.footer {
+		printRow(r, true)
+	}
+}
+
+// print prints the table with the same borders and right alignment as the stats tables.
+func (t table) print() {
+	widths := t.widths(terminalWidth())
+	lineLen := 1
+	for _, w := range widths {
+		lineLen += w + colPadding
+	}
+	line := strings.Repeat("-", lineLen)
+	colors := useColors()
+	printRow := func(cells []string, color string) {
+		b := strings.Builder{}
+		b.WriteString("|")
+		for i, c := range cells {
+			if utf8.RuneCountInString(c) > widths[i] {
+				c = string([]rune(c)[:widths[i]-1]) + "…"
+			}
+			// color after padding, the escape codes would otherwise count towards the width
+			b.WriteString(colorize(fmt.Sprintf("%*s", widths[i], c), color, colors) + " |")
+		}
+		fmt.Println(b.String())
+	}
+
+	fmt.Printf("\n%v\n", line)
+	printRow(t.headers, "")
+	fmt.Println(line)
+	for i, r := range t.rows {
+		color := ""
+		if i < len(t.rowColors) {
+			color = t.rowColors[i]
+		}
+		printRow(r, color)
+	}
+	fmt.Println(line)
+	if len(t.footer) == 0 {
+		return
+	}
+	for _, r := range t.footer {
+		printRow(r, "")
+	}
+	fmt.Println(line)
+}
+
+// widths returns the width of each column, the columns after the first are shrunk towards their content and
+// the first column is truncated, if needed, to fit the terminal width. A terminal width of 0 means it is unknown.
+func (t table) widths(termWidth int) []int {
+	widths := make([]int, len(t.headers))
+	for i, h := range t.headers {
+		values := make([]string, 0, len(t.rows)+len(t.footer))
+		for _, r := range slices.Concat(t.rows, t.footer) {
+			values = append(values, r[i])
+		}
+		widths[i] = columnWidth(h, values...)
+	}
+
+	minWidth := t.minWidth
+	if termWidth > 0 && len(widths) > 1 {
+		available := termWidth - 1 - (widths[0] + colPadding)
+		minWidth = min(minWidth, available/(len(widths)-1)-colPadding)
+	}
+	lineLen := 1 + widths[0] + colPadding
+	for i := 1; i < len(widths); i++ {
+		widths[i] = max(widths[i], minWidth)
+		lineLen += widths[i] + colPadding
+	}
+
+	if termWidth > 0 && lineLen > termWidth {
+		widths[0] = max(utf8.RuneCountInString(t.headers[0])+colPadding, widths[0]-(lineLen-termWidth))
+	}
+	return widths
+}
+
+// ANSI colors for the terminal output.
+const (
+	colorRed   = "\033[31m"
+	colorDim   = "\033[2m"
+	colorReset = "\033[0m"
+)
+
+// useColors reports whether the output should be colored, see https://no-color.org.
+func useColors() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the given ANSI color, if any and if enabled.
+func colorize(s, color string, enabled bool) string {
+	if !enabled || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// transactionSummary aggregates the transactions of a category, income is stored as negative cost so totalCost is the net value.
+type transactionSummary struct {
+	category  sql.NullString
+	totalCost cents
+	income    cents
+	count     int // of transactions
+}
+
+// expenses is the spending of the summary, without the income discounted.
+func (s transactionSummary) expenses() cents {
+	return s.totalCost + s.income
+}
+
+func (s transactionSummary) categoryName() string {
+	if !s.category.Valid {
+		return "N/A"
+	}
+	return s.category.String
+}
+
+func costAggregration(db database, startDate, endDate string) ([]transactionSummary, error) {
+	from, err := transactionsIn(db, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+SELECT
+    category,
+    SUM(cost) AS total_cost,
+    SUM(CASE WHEN cost < 0 THEN -cost ELSE 0 END) AS income,
+    COUNT(*) AS transactions
+FROM
+    `+from+`
+WHERE
+    date BETWEEN ? AND ? AND currency IS NULL -- other currencies are not summed with the base one
+GROUP BY
+    category
+ORDER BY
+    category;
+	`, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stats: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+	return scanSummaries(rows)
+}
+
+// scanSummaries reads the rows of category, total cost, income and number of transactions.
+func scanSummaries(rows *sql.Rows) ([]transactionSummary, error) {
+	var allTimeSummaries []transactionSummary
+	for rows.Next() {
+		var s transactionSummary
+		if err := rows.Scan(&s.category, &s.totalCost, &s.income, &s.count); err != nil {
+			return nil, fmt.Errorf("error scanning all time row: %w", err)
+		}
+		allTimeSummaries = append(allTimeSummaries, s)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", rows.Err())
+	}
+
+	return allTimeSummaries, nil
+}
+
+// tagCostAggregation shows the all time cost of each category of the transactions with the tag, e.g. -w tag:vacation.
+func tagCostAggregation(db database, o statsOptions, tag string) error {
+	tag = normalizeTag(tag)
+	from, err := transactionsIn(db, "0000-00-00", "9999-12-31")
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(`
+SELECT
+    category,
+    SUM(cost) AS total_cost,
+    SUM(CASE WHEN cost < 0 THEN -cost ELSE 0 END) AS income,
+    COUNT(*) AS transactions
+FROM
+    `+from+`
+WHERE
+    id IN (SELECT transaction_id FROM tags WHERE tag = ?) AND currency IS NULL
+GROUP BY
+    category
+ORDER BY
+    category;
+	`, tag)
+	if err != nil {
+		return fmt.Errorf("failed to query tag stats: %w", err)
+	}
+	defer handleErrClose(rows.Close)
+	summaries, err := scanSummaries(rows)
+	if err != nil {
+		return err
+	}
+
+	costRows, err := db.Query(`
+SELECT cost FROM `+from+` WHERE id IN (SELECT transaction_id FROM tags WHERE tag = ?) AND cost > 0 AND currency IS NULL
+ORDER BY cost
+	`, tag)
+	if err != nil {
+		return fmt.Errorf("failed to query tag expenses: %w", err)
+	}
+	defer handleErrClose(costRows.Close)
+	costs, err := scanCosts(costRows)
+	if err != nil {
+		return err
+	}
+	return summariesTable(o, "#"+tag, summaries, costs, 0) // tags span arbitrary dates, so no daily average
 }
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_weekRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		now       time.Time
+		weekStart time.Weekday
+		weeksAgo  int
+		wantStart string
+		wantEnd   string
+	}{
+		{"monday", time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"tuesday", time.Date(2023, 10, 3, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"wednesday", time.Date(2023, 10, 4, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"thursday", time.Date(2023, 10, 5, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"friday", time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"saturday", time.Date(2023, 10, 7, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"sunday", time.Date(2023, 10, 8, 12, 0, 0, 0, time.UTC), time.Monday, 0, "2023-10-02", "2023-10-08"},
+		{"last week from monday", time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC), time.Monday, 1, "2023-09-25", "2023-10-01"},
+		{"last week from sunday", time.Date(2023, 10, 8, 12, 0, 0, 0, time.UTC), time.Monday, 1, "2023-09-25", "2023-10-01"},
+		{"sunday start on saturday", time.Date(2023, 10, 7, 12, 0, 0, 0, time.UTC), time.Sunday, 0, "2023-10-01", "2023-10-07"},
+		{"sunday start on sunday", time.Date(2023, 10, 8, 12, 0, 0, 0, time.UTC), time.Sunday, 0, "2023-10-08", "2023-10-14"},
+		{"sunday start last week", time.Date(2023, 10, 8, 12, 0, 0, 0, time.UTC), time.Sunday, 1, "2023-10-01", "2023-10-07"},
+		{"across the year", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), time.Monday, 1, "2023-12-25", "2023-12-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStart, gotEnd := weekRange(tt.now, tt.weekStart, tt.weeksAgo)
+			if gotStart != tt.wantStart || gotEnd != tt.wantEnd {
+				t.Errorf("weekRange() = %s, %s, want %s, %s", gotStart, gotEnd, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func Test_colorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		color   string
+		enabled bool
+		want    string
+	}{
+		{"enabled", colorRed, true, colorRed + "food" + colorReset},
+		{"disabled", colorRed, false, "food"},
+		{"no color", "", true, "food"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := colorize("food", tt.color, tt.enabled); got != tt.want {
+				t.Errorf("colorize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_monthlyWindow(t *testing.T) {
+	months := monthlyWindow(time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC))
+	if len(months) != 12 {
+		t.Fatalf("got %d months, want 12", len(months))
+	}
+	if got := months[0].Format("2006-01-02"); got != "2023-03-01" {
+		t.Errorf("first month = %s, want 2023-03-01", got)
+	}
+	if got := months[10].Format("2006-01-02"); got != "2024-01-01" {
+		t.Errorf("month across the year = %s, want 2024-01-01", got)
+	}
+	if got := months[11].Format("2006-01-02"); got != "2024-02-01" {
+		t.Errorf("last month = %s, want 2024-02-01", got)
+	}
+}
+
+func Test_monthLayout(t *testing.T) {
+	for layout, want := range map[string]string{
+		"":                 "Jan 2006",
+		"02/01/2006":       "01/2006",
+		"Jan 2, 2006":      "Jan 2006",
+		"2006-01-02":       "2006-01",
+		"Mon, 02 Jan 2006": "Jan 2006",
+		"2.1.2006":         "1.2006",
+		"02":               "Jan 2006",
+	} {
+		if got := monthLayout(layout); got != want {
+			t.Errorf("monthLayout(%q) = %q, want %q", layout, got, want)
+		}
+	}
+}
+
+func Test_weeklyWindow(t *testing.T) {
+	weeks := weeklyWindow(time.Date(2023, 10, 17, 12, 0, 0, 0, time.UTC), time.Monday)
+	want := []matrixPeriod{
+		{"Week of Sep 25", "2023-09-25", "2023-10-01"},
+		{"Week of Oct 02", "2023-10-02", "2023-10-08"},
+		{"Week of Oct 09", "2023-10-09", "2023-10-15"},
+		{"Week of Oct 16", "2023-10-16", "2023-10-22"},
+	}
+	if !slices.Equal(weeks, want) {
+		t.Errorf("weeklyWindow() = %v, want %v", weeks, want)
+	}
+
+	weeks = weeklyWindow(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), time.Sunday)
+	if want := []matrixPeriod{{"Week of Jan 01", "2023-01-01", "2023-01-07"}}; !slices.Equal(weeks, want) {
+		t.Errorf("weeklyWindow() on a sunday start = %v, want %v", weeks, want)
+	}
+}
+
+func Benchmark_monthlyCostAggregation(b *testing.B) {
+	db := newTestDB(b)
+	err := withTx(db, func(tx database) error {
+		start := time.Now().AddDate(-2, 0, 0)
+		for i := range 100_000 {
+			date := start.AddDate(0, 0, i%730).Format("2006-01-02")
+			if _, err := insertTransaction(tx, cents(i%5000), fmt.Sprintf("category%d", i%20), "", date); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	b.Cleanup(func() { os.Stdout = stdout })
+
+	for b.Loop() {
+		if err := monthlyCostAggregation(db, statsOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_median(t *testing.T) {
+	tests := []struct {
+		name  string
+		costs []cents
+		want  cents
+	}{
+		{"empty", nil, 0},
+		{"single", []cents{500}, 500},
+		{"odd", []cents{100, 200, 900}, 200},
+		{"even", []cents{100, 200, 300, 900}, 250},
+		{"even rounding", []cents{100, 101}, 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := median(tt.costs); got != tt.want {
+				t.Errorf("median(%v) = %v, want %v", tt.costs, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_meanStdDev(t *testing.T) {
+	tests := []struct {
+		name         string
+		costs        []cents
+		mean, stdDev float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", []cents{500}, 500, 0},
+		{"spread", []cents{200, 400, 400, 400, 500, 500, 700, 900}, 500, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, stdDev := meanStdDev(tt.costs)
+			if mean != tt.mean || stdDev != tt.stdDev {
+				t.Errorf("meanStdDev(%v) = %v, %v, want %v, %v", tt.costs, mean, stdDev, tt.mean, tt.stdDev)
+			}
+		})
+	}
+}
+
+func Test_project(t *testing.T) {
+	tests := []struct {
+		spent         cents
+		elapsed, days int
+		want          cents
+	}{
+		{30000, 10, 30, 90000},
+		{1000, 30, 30, 1000},
+		{100, 3, 31, 1033},
+		{500, 0, 31, 500},
+	}
+	for _, tt := range tests {
+		if got := project(tt.spent, tt.elapsed, tt.days); got != tt.want {
+			t.Errorf("project(%v, %d, %d) = %v, want %v", tt.spent, tt.elapsed, tt.days, got, tt.want)
+		}
+	}
+}
+
+func Test_sortSummaries(t *testing.T) {
+	summary := func(category string, cost cents, count int) transactionSummary {
+		return transactionSummary{category: sql.NullString{String: category, Valid: category != ""}, totalCost: cost, count: count}
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"cost-asc", []string{"N/A", "coffee", "snacks", "lunch", "rent"}},
+		{"cost-desc", []string{"rent", "lunch", "N/A", "coffee", "snacks"}},
+		{"category", []string{"N/A", "coffee", "lunch", "rent", "snacks"}},
+		{"count", []string{"coffee", "lunch", "snacks", "rent", "N/A"}},
+	}
+	for _, tt := range tests {
+		for range 10 { // any starting order sorts the same
+			summaries := []transactionSummary{
+				summary("rent", 85000, 1), summary("lunch", 1080, 3), summary("", 1020, 1), summary("coffee", 1020, 6), summary("snacks", 1020, 3),
+			}
+			rand.Shuffle(len(summaries), func(i, j int) { summaries[i], summaries[j] = summaries[j], summaries[i] })
+			sortSummaries(summaries, tt.order)
+			var got []string
+			for _, s := range summaries {
+				got = append(got, s.categoryName())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("sortSummaries(%s) = %q, want %q", tt.order, got, tt.want)
+			}
+		}
+	}
+}
+
+func Test_foldSmallShares(t *testing.T) {
+	summary := func(category string, cost cents, count int) transactionSummary {
+		return transactionSummary{category: sql.NullString{String: category, Valid: true}, totalCost: cost, count: count}
+	}
+	summaries := []transactionSummary{
+		summary("rent", 85000, 1), summary("food", 12000, 20), summary("stamps", 1500, 1), summary("gift", 1500, 2),
+		summary("salary", -200000, 1),
+	}
+	kept, other := foldSmallShares(slices.Clone(summaries), 100000, 2)
+	var got []string
+	for _, s := range kept {
+		got = append(got, s.categoryName())
+	}
+	if want := []string{"rent", "food", "salary"}; !slices.Equal(got, want) {
+		t.Errorf("foldSmallShares() kept %q, want %q", got, want)
+	}
+	if other.totalCost != 3000 || other.count != 3 {
+		t.Errorf("foldSmallShares() other = %+v, want a cost of 3000 in 3 transactions", other)
+	}
+
+	kept, other = foldSmallShares(slices.Clone(summaries), 100000, 0)
+	if len(kept) != len(summaries) || other.count != 0 {
+		t.Errorf("foldSmallShares() without a minimum share = %d kept, other %+v, want all kept", len(kept), other)
+	}
+}
+
+func Test_growth(t *testing.T) {
+	tests := []struct {
+		current, previous cents
+		want              string
+	}{
+		{0, 0, ""},
+		{500, 0, "new"},
+		{1250, 1000, "+25.0%"},
+		{0, 1000, "-100.0%"},
+		{-500, -1000, "+50.0%"},
+	}
+	for _, tt := range tests {
+		if got := growth(tt.current, tt.previous); got != tt.want {
+			t.Errorf("growth(%v, %v) = %q, want %q", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func Test_windowDays(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)
+	if days, err := windowDays(db, "0000-00-00", "9999-12-31", now); err != nil || days != 0 {
+		t.Errorf("got %d days, %v for an empty database, want 0", days, err)
+	}
+	if _, err := insertTransaction(db, 1, "food", "", "2023-10-06"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		want       int
+	}{
+		{"past month", "2023-09-01", "2023-09-30", 30},
+		{"current month", "2023-10-01", "2023-10-31", 15},
+		{"all time", "0000-00-00", "9999-12-31", 10},
+		{"future", "2023-11-01", "2023-11-30", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, err := windowDays(db, tt.start, tt.end, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if days != tt.want {
+				t.Errorf("windowDays(%s, %s) = %d, want %d", tt.start, tt.end, days, tt.want)
+			}
+		})
+	}
+}
+
+// tableRecorder is a tableRenderer keeping the tables instead of printing them.
+type tableRecorder struct{ tables *[]table }
+
+func (r tableRecorder) render(t table) { *r.tables = append(*r.tables, t) }
+
+func Test_summariesTableReportCurrency(t *testing.T) {
+	summaries := []transactionSummary{
+		{category: sql.NullString{String: "rent", Valid: true}, totalCost: 85000, count: 1},
+		{category: sql.NullString{String: "salary", Valid: true}, totalCost: -200000, income: 200000, count: 1},
+	}
+	var tables []table
+	o := statsOptions{currency: "€", reportCurrency: "$", reportRate: 1.08, renderer: tableRecorder{&tables}}
+	if err := summariesTable(o, "this month", summaries, []cents{85000}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 {
+		t.Fatalf("got %d tables, want 1", len(tables))
+	}
+	got := tables[0]
+	if want := []string{"Category", "Cost", "Cost in $", "Share"}; !slices.Equal(got.headers, want) {
+		t.Errorf("got headers %q, want %q", got.headers, want)
+	}
+	if want := []string{"rent", "€850.00", "$918.00", "100.0%"}; !slices.Equal(got.rows[0], want) {
+		t.Errorf("got row %q, want %q", got.rows[0], want)
+	}
+	if want := []string{"Income", "€2000.00", "$2160.00", ""}; !slices.Equal(got.footer[1], want) {
+		t.Errorf("got footer %q, want %q", got.footer[1], want)
+	}
+	if want := []string{"Transactions", "1", "", ""}; !slices.Equal(got.footer[3], want) {
+		t.Errorf("got footer %q, want %q", got.footer[3], want)
+	}
+
+	tables = nil
+	o.reportRate = 0
+	if err := summariesTable(o, "this month", summaries, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Category", "Cost", "Share"}; !slices.Equal(tables[0].headers, want) {
+		t.Errorf("got headers %q without a rate, want %q", tables[0].headers, want)
+	}
+}
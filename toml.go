@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOMLConfig parses the subset of TOML the config needs: [section] tables and key = value pairs, where the keys
+// are bare or quoted and the values are strings, numbers or booleans.
+func parseTOMLConfig(b []byte) ([]configEntry, error) {
+	var (
+		entries []configEntry
+		section string
+	)
+	for i, line := range strings.Split(string(b), "\n") {
+		lineNumber := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue // skip empty lines and comments
+		}
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("%w: arrays of tables are not supported, line %d", errUser, lineNumber)
+			}
+			header, rest, ok := strings.Cut(line[1:], "]")
+			if !ok || !isTOMLComment(rest) {
+				return nil, fmt.Errorf("%w: invalid table header %q, line %d", errUser, line, lineNumber)
+			}
+			key, rest, err := tomlKey(strings.TrimSpace(header))
+			if err != nil || rest != "" {
+				return nil, fmt.Errorf("%w: invalid table name %q, line %d", errUser, header, lineNumber)
+			}
+			section = key
+			continue
+		}
+
+		key, rest, err := tomlKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w, line %d", err, lineNumber)
+		}
+		rest, ok := strings.CutPrefix(strings.TrimSpace(rest), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: expecting key = value, line %d", errUser, lineNumber)
+		}
+		value, err := tomlValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("%w, line %d", err, lineNumber)
+		}
+		entries = append(entries, configEntry{section: section, key: key, value: value, hasValue: true, line: lineNumber})
+	}
+	return entries, nil
+}
+
+// tomlKey reads the bare or quoted key at the start of s, returning it and what follows.
+func tomlKey(s string) (string, string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return tomlString(s)
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+	})
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("%w: missing key in %q", errUser, s)
+	}
+	if rest := strings.TrimSpace(s[end:]); strings.HasPrefix(rest, ".") {
+		return "", "", fmt.Errorf("%w: dotted keys are not supported in %q", errUser, s)
+	}
+	return s[:end], s[end:], nil
+}
+
+// tomlValue reads a string, number or boolean value, followed by an optional comment.
+func tomlValue(s string) (string, error) {
+	switch {
+	case s == "" || strings.HasPrefix(s, "#"):
+		return "", fmt.Errorf("%w: missing value", errUser)
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		value, rest, err := tomlString(s)
+		if err != nil {
+			return "", err
+		}
+		if !isTOMLComment(rest) {
+			return "", fmt.Errorf("%w: unexpected %q after value", errUser, strings.TrimSpace(rest))
+		}
+		return value, nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		return "", fmt.Errorf("%w: arrays and inline tables are not supported", errUser)
+	default:
+		value, _, _ := strings.Cut(s, "#")
+		value = strings.TrimSpace(value)
+		if value != "true" && value != "false" {
+			if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+				return "", fmt.Errorf("%w: invalid value %q, strings must be quoted", errUser, value)
+			}
+			value = strings.ReplaceAll(value, "_", "")
+		}
+		return value, nil
+	}
+}
+
+// tomlString reads the basic ("...") or literal ('...') string at the start of s, returning it and what follows.
+func tomlString(s string) (string, string, error) {
+	if strings.HasPrefix(s, "'") {
+		value, rest, ok := strings.Cut(s[1:], "'")
+		if !ok {
+			return "", "", fmt.Errorf("%w: unterminated string %s", errUser, s)
+		}
+		return value, rest, nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("%w: invalid string %s", errUser, s[:i+1])
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: unterminated string %s", errUser, s)
+}
+
+func isTOMLComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
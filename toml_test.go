@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func Test_parseTOMLConfig(t *testing.T) {
+	config := `
+# liet config
+database = "/data/liet.db" # inline comment
+currency = '€'
+number_format = "1 234,56"
+
+[budgets]
+food = 300
+"eating out" = 1_000.50
+`
+	got, err := parseTOMLConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []configEntry{
+		{"", "database", "/data/liet.db", true, 3},
+		{"", "currency", "€", true, 4},
+		{"", "number_format", "1 234,56", true, 5},
+		{"budgets", "food", "300", true, 8},
+		{"budgets", "eating out", "1000.50", true, 9},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseTOMLConfig() = %v, want %v", got, want)
+	}
+
+	configs := []string{"currency = €", "database", `database = "unterminated`, "[[budgets]]", "budgets.food = 1", "x = [1, 2]"}
+	for _, invalid := range configs {
+		if _, err := parseTOMLConfig([]byte(invalid)); !errors.Is(err, errUser) {
+			t.Errorf("parseTOMLConfig(%q) = %v, want an user error", invalid, err)
+		}
+	}
+}
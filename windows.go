@@ -2,8 +2,40 @@
 
 package main
 
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
 const (
 	defaultConfigFile   = `.liet.conf`
 	defaultDatabaseFile = `AppData\Local\liet.db`
+	defaultLedgersDir   = `AppData\Local\liet`
 	defaultLogFile      = `AppData\Local\liet.log`
 )
+
+// userPath returns the path of a default file under the home directory.
+func userPath(homeDir, file string) string {
+	return filepath.Join(homeDir, file)
+}
+
+// terminalColumns returns 0 as the terminal width is not detected on windows, set COLUMNS instead.
+func terminalColumns() int {
+	return 0
+}
+
+// setEcho fails as the terminal echo is not controlled on windows, set LIET_PASSPHRASE instead of typing it.
+func setEcho(bool) error {
+	return errors.New("the terminal echo cannot be turned off on windows")
+}
+
+// openFile opens a file with the default application of its type, as a double click in the explorer would.
+func openFile(path string) error {
+	//nolint:gosec // the path of a receipt recorded by the user
+	if err := exec.Command("rundll32", "url.dll,FileProtocolHandler", path).Run(); err != nil {
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	return nil
+}